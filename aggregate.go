@@ -0,0 +1,196 @@
+package calculator
+
+import (
+	"sort"
+)
+
+// Outcome is the result of one formula passed to an Aggregator
+type Outcome struct {
+	Name   string
+	Color  Color
+	Weight float64
+	Value  Value
+}
+
+// Aggregator chooses the color of a set of parameters.
+// Outcomes are passed in the order of formulas in Compile, disabled formulas are skipped.
+// It returns the color and names of formulas which determined it.
+type Aggregator interface {
+	Aggregate(palette Palette, outcomes []Outcome) (Color, []string)
+}
+
+// AggregatorFunc allows to use an ordinary function as an Aggregator
+type AggregatorFunc func(palette Palette, outcomes []Outcome) (Color, []string)
+
+func (f AggregatorFunc) Aggregate(palette Palette, outcomes []Outcome) (Color, []string) {
+	return f(palette, outcomes)
+}
+
+// WorstOf chooses the highest color among true formulas
+func WorstOf() Aggregator {
+	return AggregatorFunc(func(palette Palette, outcomes []Outcome) (Color, []string) {
+		precedence := levelIndexes(palette)
+		resColor := palette.Default
+
+		for _, outcome := range outcomes {
+			if outcome.Value.Result && precedence[outcome.Color] > precedence[resColor] {
+				resColor = outcome.Color
+			}
+		}
+
+		return resColor, triggeredWithColor(outcomes, resColor)
+	})
+}
+
+// FirstMatch chooses the color of the first true formula
+func FirstMatch() Aggregator {
+	return AggregatorFunc(func(palette Palette, outcomes []Outcome) (Color, []string) {
+		for _, outcome := range outcomes {
+			if outcome.Value.Result {
+				return outcome.Color, []string{outcome.Name}
+			}
+		}
+
+		return palette.Default, nil
+	})
+}
+
+// Band maps scores starting from Min to Color
+type Band struct {
+	Min   float64
+	Color Color
+}
+
+// WeightedScore sums weights of true formulas and chooses the band with the highest Min not greater than the sum.
+// If the sum is lower than all bands, the default color is chosen.
+func WeightedScore(bands []Band) Aggregator {
+	sorted := make([]Band, len(bands))
+	copy(sorted, bands)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Min < sorted[j].Min
+	})
+
+	return weightedScore{bands: sorted}
+}
+
+type weightedScore struct {
+	bands []Band
+}
+
+func (a weightedScore) Aggregate(palette Palette, outcomes []Outcome) (Color, []string) {
+	var (
+		score     float64
+		triggered []string
+	)
+
+	for _, outcome := range outcomes {
+		if outcome.Value.Result && outcome.Weight != 0 {
+			score += outcome.Weight
+			triggered = append(triggered, outcome.Name)
+		}
+	}
+
+	resColor := palette.Default
+
+	for _, band := range a.bands {
+		if score < band.Min {
+			break
+		}
+
+		resColor = band.Color
+	}
+
+	if resColor == palette.Default {
+		return resColor, nil
+	}
+
+	return resColor, triggered
+}
+
+func (a weightedScore) colors() []Color {
+	res := make([]Color, 0, len(a.bands))
+	for _, band := range a.bands {
+		res = append(res, band.Color)
+	}
+
+	return res
+}
+
+// Threshold chooses the highest color which has at least 'minTriggered[color]' true formulas ("N of M").
+// Colors missing from 'minTriggered' need one true formula.
+func Threshold(minTriggered map[Color]int) Aggregator {
+	return threshold{minTriggered: minTriggered}
+}
+
+type threshold struct {
+	minTriggered map[Color]int
+}
+
+func (a threshold) Aggregate(palette Palette, outcomes []Outcome) (Color, []string) {
+	for i := len(palette.Levels) - 1; i >= 0; i-- {
+		color := palette.Levels[i]
+
+		n, ok := a.minTriggered[color]
+		if !ok {
+			n = 1
+		}
+
+		triggered := triggeredWithColor(outcomes, color)
+		if len(triggered) > 0 && len(triggered) >= n {
+			return color, triggered
+		}
+	}
+
+	return palette.Default, nil
+}
+
+func (a threshold) colors() []Color {
+	res := make([]Color, 0, len(a.minTriggered))
+	for color := range a.minTriggered {
+		res = append(res, color)
+	}
+
+	return res
+}
+
+// coloredAggregator is an Aggregator configured with colors, they are checked against the palette by Compile
+type coloredAggregator interface {
+	colors() []Color
+}
+
+// checkAggregatorColors checks that colors the aggregator is configured with are palette levels
+func checkAggregatorColors(aggregator Aggregator, precedence map[Color]int) error {
+	colored, ok := aggregator.(coloredAggregator)
+	if !ok {
+		return nil
+	}
+
+	for _, color := range colored.colors() {
+		if _, ok := precedence[color]; !ok {
+			return &PaletteError{Reason: errAggregatorColor, Color: color}
+		}
+	}
+
+	return nil
+}
+
+func triggeredWithColor(outcomes []Outcome, color Color) []string {
+	var res []string
+
+	for _, outcome := range outcomes {
+		if outcome.Value.Result && outcome.Color == color {
+			res = append(res, outcome.Name)
+		}
+	}
+
+	return res
+}
+
+func levelIndexes(palette Palette) map[Color]int {
+	res := make(map[Color]int, len(palette.Levels))
+	for i, level := range palette.Levels {
+		res[level] = i
+	}
+
+	return res
+}
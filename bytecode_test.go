@@ -0,0 +1,233 @@
+// nolint:gochecknoglobals
+package calculator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/egelis/calculator/core"
+)
+
+var (
+	bytecodeParams = map[string]any{
+		"s2001":          2000000.0,
+		"s6004":          10.5,
+		"stated_capital": "50",
+		"bool_param":     false,
+		"name":           "ООО Ромашка",
+		"reg_date":       "2015-06-30",
+		"null_param":     nil,
+		"tags":           []any{},
+	}
+
+	bytecodeTypes = map[string]core.ValueType{
+		"s2001":      core.NUMBER_TYPE,
+		"s6004":      core.NUMBER_TYPE,
+		"bool_param": core.BOOL_TYPE,
+		"name":       core.STRING_TYPE,
+		"reg_date":   core.DATE_TYPE,
+		"null_param": core.NUMBER_TYPE,
+	}
+
+	raceEnabled bool
+
+	benchmarkExpression = "s2001 > 1000000 AND s6004 / 2 < 10 OR bool_param = true AND s2001 - s6004 * 3 >= 0"
+)
+
+// compileExpression parses the expression like Compile does without checks of types
+func compileExpression(t testing.TB, expression string) []core.Token {
+	t.Helper()
+
+	tokens, err := tokenize(expression, bytecodeTypes)
+	if err != nil {
+		t.Fatalf("tokenize() got error = \"%v\", expected nil", err)
+	}
+
+	infix, err := newParser(tokens).start()
+	if err != nil {
+		t.Fatalf("start() got error = \"%v\", expected nil", err)
+	}
+
+	postfix, err := core.ToPostfixExp(infix)
+	if err != nil {
+		t.Fatalf("ToPostfixExp() got error = \"%v\", expected nil", err)
+	}
+
+	return postfix
+}
+
+func TestBytecodeMatchesInterpreter(t *testing.T) {
+	t.Parallel()
+
+	expressions := []string{
+		benchmarkExpression,
+		"s2001 + s6004",
+		"s6004 / 3 * 3",
+		"s2001 / 0 > 1",
+		"let x = s2001 * 2, y = x + 1 in y > x",
+		"stated_capital > 10",
+		"stated_capital = \"50\"",
+		"name = \"ООО Ромашка\" AND len(name) = 11",
+		"upper(substring(name, 4)) matches `^РОМ`",
+		"reg_date < date(\"2020-01-01\") AND year(reg_date) = 2015",
+		"add_days(reg_date, 1) > reg_date",
+		"null_param > 0",
+		"missing_param > 0 OR true",
+		"exists(null_param) OR exists(missing_param) OR exists(s2001)",
+		"coalesce(null_param, missing_param, s6004) = 10.5",
+		"ifnull(missing_param, 1) + 1",
+		"empty(tags) AND empty(null_param)",
+		"bool_param = 1",
+		"s2001 = bool_param",
+		"string(s6004 + 1) = \"11.5\"",
+		"number(stated_capital) * 2",
+		"tags > 0",
+		"s2001 > 0 AND coalesce(null_param)",
+		"1=2 AND 1=1 OR 1=1",
+		"true AND s2001 > 0 AND (s6004 < 0 OR false)",
+		"let c = 2 * 3, d = s6004 in d > c AND true",
+		"len(\"abc\") + s6004 > 3",
+		"true AND bool_param",
+		"1 / 0 > 1 OR true",
+		"number(\"x\") > 1 AND false",
+		"true AND null_param > 0",
+	}
+
+	source := core.MapSource(bytecodeParams)
+
+	for _, lenient := range []bool{false, true} {
+		for _, expression := range expressions {
+			lenient, expression := lenient, expression
+
+			t.Run(fmt.Sprintf("%s lenient=%t", expression, lenient), func(t *testing.T) {
+				t.Parallel()
+
+				postfix := compileExpression(t, expression)
+
+				bytecode, err := core.CompileBytecode(core.Optimize(postfix))
+				if err != nil {
+					t.Fatalf("CompileBytecode() got error = \"%v\", expected nil", err)
+				}
+
+				opts := core.Options{Lenient: lenient, Now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+				expected, _, expectedErr := core.EvaluateWithOptions(context.Background(), postfix, source, opts)
+				res, err := bytecode.Evaluate(context.Background(), source, nil, opts)
+
+				if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+					t.Fatalf("Evaluate() got error = \"%v\", expected = \"%v\"", err, expectedErr)
+				}
+
+				if res != expected {
+					t.Errorf("Evaluate() got %+v, expected = %+v", res, expected)
+				}
+			})
+		}
+	}
+}
+
+func TestBytecodeAllocations(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are counted without the race detector")
+	}
+
+	postfix := compileExpression(t, benchmarkExpression)
+
+	bytecode, err := core.CompileBytecode(postfix)
+	if err != nil {
+		t.Fatalf("CompileBytecode() got error = \"%v\", expected nil", err)
+	}
+
+	raw := map[string]json.RawMessage{}
+	for name, value := range bytecodeParams {
+		raw[name], _ = json.Marshal(value)
+	}
+
+	ctx := context.Background()
+
+	// Raw JSON numbers are decoded to json.Number, which allocates its string and the interface value,
+	// parameters are looked up once, so only s2001 and s6004 allocate
+	for name, test := range map[string]struct {
+		source    core.ParamSource
+		maxAllocs float64
+	}{
+		"MapSource": {source: core.MapSource(bytecodeParams), maxAllocs: 0},
+		"RawSource": {source: core.RawSource(raw), maxAllocs: 4},
+	} {
+		allocs := testing.AllocsPerRun(100, func() {
+			if _, err := bytecode.Evaluate(ctx, test.source, nil, core.Options{}); err != nil {
+				t.Fatalf("Evaluate() got error = \"%v\", expected nil", err)
+			}
+		})
+
+		if allocs > test.maxAllocs {
+			t.Errorf("Evaluate() with %s got %v allocations, expected at most %v", name, allocs, test.maxAllocs)
+		}
+	}
+}
+
+func BenchmarkInterpreter(b *testing.B) {
+	postfix := compileExpression(b, benchmarkExpression)
+	source := core.MapSource(bytecodeParams)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := core.EvaluateWithOptions(ctx, postfix, source, core.Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBytecode(b *testing.B) {
+	bytecode, err := core.CompileBytecode(compileExpression(b, benchmarkExpression))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	source := core.MapSource(bytecodeParams)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := bytecode.Evaluate(ctx, source, nil, core.Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProgram(b *testing.B) {
+	formulas := []Formula{
+		{Name: "formula_1", Expression: benchmarkExpression, Color: RedColor, IsEnable: true},
+		{Name: "formula_2", Expression: "@formula_1 = false AND s6004 > 10", Color: YellowColor, IsEnable: true},
+	}
+
+	for _, explain := range []bool{true, false} {
+		var opts []Option
+		if explain {
+			opts = append(opts, WithExplain())
+		}
+
+		program, err := Compile(formulas, bytecodeTypes, opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		sources := []core.ParamSource{core.MapSource(bytecodeParams)}
+
+		b.Run(fmt.Sprintf("explain=%t", explain), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, _, err := program.CalculateSources(sources); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
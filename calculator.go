@@ -1,7 +1,10 @@
 package calculator
 
 import (
+	"context"
+	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/egelis/calculator/core"
 	"github.com/egelis/jparser"
@@ -23,84 +26,127 @@ type Formula struct {
 	Color      Color
 	Version    int64
 	IsEnable   bool
+	// Weight is used by the WeightedScore aggregator
+	Weight float64
+	// ResultType is core.BOOL_TYPE (default) for rules and core.NUMBER_TYPE for scores.
+	// Scores don't affect the color.
+	ResultType core.ValueType
 }
 
 type (
 	FormulaResult map[string]Value
 
 	Value struct {
-		Version int64 `json:"version"`
-		Color   Color `json:"color"`
-		Result  bool  `json:"result"`
+		Version int64    `json:"version"`
+		Color   Color    `json:"color"`
+		Result  bool     `json:"result"`
+		Number  *float64 `json:"number,omitempty"`
+		// Trace is filled when the program is compiled WithExplain
+		Trace []*core.Trace `json:"trace,omitempty"`
 	}
 )
 
-// Calculate calculates each formula from 'formulas' for each set of parameters from 'rawSets'
+// Explanation renders traces of local variables and of the whole formula
+func (v Value) Explanation() string {
+	lines := make([]string, 0, len(v.Trace))
+	for _, trace := range v.Trace {
+		lines = append(lines, trace.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+type ResultTypeError struct {
+	Formula  string
+	Expected core.ValueType
+	Got      core.ValueType
+}
+
+func (e *ResultTypeError) Error() string {
+	return fmt.Sprintf("formula '%s' must return '%s', got '%s'", e.Formula, e.Expected, e.Got)
+}
+
+// Calculate calculates each formula from 'formulas' for each set of parameters from 'rawSets'.
+// A formula can use the result of another formula via '@formula_name'.
 func Calculate(formulas []Formula, rawSets []jparser.RawMessageSet, paramTypes map[string]core.ValueType,
 ) (Color, []FormulaResult, error) {
-	tokenizedFormulas, err := getTokenizedFormulas(formulas, paramTypes)
+	program, err := Compile(formulas, paramTypes)
 	if err != nil {
 		return BlackColor, nil, err
 	}
 
-	// For the situation where we have formulas without rawSet
-	if len(rawSets) == 0 {
-		rawSets = []jparser.RawMessageSet{nil}
+	return program.Calculate(rawSets)
+}
+
+// CalculateContext calculates formulas like Calculate and stops when the context is done.
+// In this case results of already calculated sets are returned along with ctx.Err().
+func CalculateContext(ctx context.Context, formulas []Formula, rawSets []jparser.RawMessageSet,
+	paramTypes map[string]core.ValueType,
+) (Color, []FormulaResult, error) {
+	program, err := Compile(formulas, paramTypes)
+	if err != nil {
+		return BlackColor, nil, err
 	}
 
-	formulaResults := make([]FormulaResult, 0, len(rawSets))
-	resColor := GreyColor
+	return program.CalculateContext(ctx, rawSets)
+}
 
-	for _, rawSet := range rawSets {
-		result := FormulaResult{}
+type tokenizedFormula struct {
+	Tokens []core.Token
+	// Postfix is the parsed formula, it is the same for all sets of parameters
+	Postfix []core.Token
+	// Optimized is the Postfix without constant and shared subexpressions
+	Optimized []core.Token
+	// Bytecode is the compiled Optimized, it is evaluated unless traces are needed
+	Bytecode *core.Bytecode
+	// Patterns are compiled literal patterns of the formula
+	Patterns   core.Patterns
+	Warnings   []Warning
+	Refs       []string
+	Name       string
+	Version    int64
+	Color      Color
+	Weight     float64
+	ResultType core.ValueType
+}
 
-		for _, formula := range tokenizedFormulas {
-			resToken, err := newParser(formula.Tokens, rawSet).start()
-			if err != nil {
-				return BlackColor, nil, err
-			}
+// newValue converts the result of the formula calculation to Value.
+// An empty token means that the formula can't be calculated because of a missing parameter.
+func newValue(formula tokenizedFormula, token core.Token) (Value, error) {
+	res := Value{
+		Version: formula.Version,
+		Color:   formula.Color,
+	}
 
-			resValue, err := strconv.ParseBool(resToken.Value)
-			if err != nil {
-				return BlackColor, nil, err
-			}
+	if token.ValueType == "" {
+		return res, nil
+	}
 
-			if resValue && colorPrecedence[formula.Color] > colorPrecedence[resColor] {
-				resColor = formula.Color
-			}
+	if token.ValueType != formula.ResultType {
+		return Value{}, &ResultTypeError{Formula: formula.Name, Expected: formula.ResultType, Got: token.ValueType}
+	}
 
-			result[formula.Name] = Value{
-				Version: formula.Version,
-				Color:   formula.Color,
-				Result:  resValue,
-			}
-		}
+	var err error
 
-		if len(result) > 0 {
-			formulaResults = append(formulaResults, result)
-		}
-	}
+	switch formula.ResultType {
+	case core.NUMBER_TYPE:
+		var number float64
 
-	return resColor, formulaResults, nil
-}
+		number, err = strconv.ParseFloat(token.Value, 64)
+		res.Number = &number
+	default:
+		res.Result, err = strconv.ParseBool(token.Value)
+	}
 
-// nolint:gochecknoglobals
-var colorPrecedence = map[Color]int{
-	BlackColor:  0,
-	GreyColor:   1,
-	GreenColor:  2,
-	YellowColor: 3,
-	RedColor:    4,
-}
+	if err != nil {
+		return Value{}, err
+	}
 
-type tokenizedFormula struct {
-	Tokens  []core.Token
-	Name    string
-	Version int64
-	Color   Color
+	return res, nil
 }
 
-func getTokenizedFormulas(formulas []Formula, paramTypes map[string]core.ValueType) ([]tokenizedFormula, error) {
+func getTokenizedFormulas(formulas []Formula, paramTypes map[string]core.ValueType, limits Limits,
+) ([]tokenizedFormula, error) {
 	res := make([]tokenizedFormula, 0, len(formulas))
 
 	for _, formula := range formulas {
@@ -108,18 +154,40 @@ func getTokenizedFormulas(formulas []Formula, paramTypes map[string]core.ValueTy
 			continue
 		}
 
+		resultType := formula.ResultType
+		switch resultType {
+		case "":
+			resultType = core.BOOL_TYPE
+		case core.BOOL_TYPE, core.NUMBER_TYPE:
+		default:
+			return nil, &ResultTypeError{Formula: formula.Name, Expected: core.BOOL_TYPE, Got: resultType}
+		}
+
+		if err := limits.checkExpression(formula); err != nil {
+			return nil, err
+		}
+
 		formulaTokens, err := tokenize(formula.Expression, paramTypes)
 		if err != nil {
 			return nil, err
 		}
 
+		if err := limits.checkTokens(formula.Name, formulaTokens); err != nil {
+			return nil, err
+		}
+
 		res = append(res, tokenizedFormula{
-			Tokens:  formulaTokens,
-			Name:    formula.Name,
-			Version: formula.Version,
-			Color:   formula.Color,
+			Tokens:     formulaTokens,
+			Refs:       formulaRefs(formulaTokens),
+			Name:       formula.Name,
+			Version:    formula.Version,
+			Color:      formula.Color,
+			Weight:     formula.Weight,
+			ResultType: resultType,
 		})
 	}
 
+	setFormulaRefTypes(res)
+
 	return res, nil
 }
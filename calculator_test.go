@@ -3,6 +3,7 @@ package calculator
 
 import (
 	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -459,6 +460,450 @@ func TestCalculateSuccess(t *testing.T) {
 				},
 			},
 		},
+
+		{
+			name: "local variables",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "let ratio = s2001 / s6004, half = ratio * 0.5 in ratio > 0.1 AND half < ratio",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+			expectedColor: GreenColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   GreenColor,
+						Result:  true,
+					},
+				},
+			},
+		},
+
+		{
+			name: "formula references",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "@formula_2 AND @formula_3",
+						Color:      RedColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+					{
+						Name:       "formula_2",
+						Expression: "s2001 > 1000000",
+						Color:      GreenColor,
+						Version:    1,
+						IsEnable:   true,
+					},
+					{
+						Name:       "formula_3",
+						Expression: "@formula_2 = false OR bool_param = false",
+						Color:      YellowColor,
+						Version:    2,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+					"formula_2": {
+						Version: 1,
+						Color:   GreenColor,
+						Result:  true,
+					},
+					"formula_3": {
+						Version: 2,
+						Color:   YellowColor,
+						Result:  true,
+					},
+				},
+			},
+		},
+
+		{
+			name: "numeric formulas",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "score",
+						Expression: "0.3 * s2001 + 0.7 * s6004",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
+					},
+					{
+						Name:       "unknown_score",
+						Expression: "unknown_param * 2",
+						Color:      GreenColor,
+						Version:    1,
+						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
+					},
+					{
+						Name:       "high_score",
+						Expression: "@score > 600000",
+						Color:      YellowColor,
+						Version:    2,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+			expectedColor: YellowColor,
+			expectedRes: []FormulaResult{
+				{
+					"score": {
+						Version: 0,
+						Color:   GreenColor,
+						Number:  floatPtr(600007),
+					},
+					"unknown_score": {
+						Version: 1,
+						Color:   GreenColor,
+					},
+					"high_score": {
+						Version: 2,
+						Color:   YellowColor,
+						Result:  true,
+					},
+				},
+			},
+		},
+
+		{
+			name: "type conversions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name: "formula_1",
+						Expression: `number(revenue) > 1000 AND date(reg_date, "02.01.2006") < date("2010-01-01") ` +
+							`AND string(okved) = "64.19" AND bool("true") AND string(s2001 / 4) = "500000"`,
+						Color:    RedColor,
+						Version:  0,
+						IsEnable: true,
+					},
+					{
+						Name:       "formula_2",
+						Expression: "number(true) + number(revenue)",
+						Color:      GreenColor,
+						Version:    1,
+						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"s2001":    json.RawMessage(`2000000`),
+						"revenue":  json.RawMessage(`"1500"`),
+						"reg_date": json.RawMessage(`"03.10.2008"`),
+						"okved":    json.RawMessage(`64.19`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"s2001":    core.NUMBER_TYPE,
+					"revenue":  core.STRING_TYPE,
+					"reg_date": core.STRING_TYPE,
+					"okved":    core.NUMBER_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+					"formula_2": {
+						Version: 1,
+						Color:   GreenColor,
+						Number:  floatPtr(1501),
+					},
+				},
+			},
+		},
+
+		{
+			name: "string functions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name: "formula_1",
+						Expression: `startsWith(okved, "64") AND contains(lower(name), "холдинг") AND len(inn) = 12 ` +
+							`AND upper(trim("  ab ")) = "AB" AND substring(name, 0, 3) = "ООО" AND substring(inn, 10) = "27"`,
+						Color:    RedColor,
+						Version:  0,
+						IsEnable: true,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"okved": json.RawMessage(`"64.19"`),
+						"name":  json.RawMessage(`"ООО \"Ромашка ХОЛДИНГ\""`),
+						"inn":   json.RawMessage(`"666300312727"`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"okved": core.STRING_TYPE,
+					"name":  core.STRING_TYPE,
+					"inn":   core.STRING_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+				},
+			},
+		},
+
+		{
+			name: "regular expressions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "inn matches `^\\d{10}(\\d{2})?$` AND regex(email, \"@(mail|yandex)\\\\.ru$\")",
+						Color:      RedColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+					{
+						Name:       "formula_2",
+						Expression: "email matches pattern",
+						Color:      GreenColor,
+						Version:    1,
+						IsEnable:   true,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"inn":     json.RawMessage(`"6663003127"`),
+						"email":   json.RawMessage(`"info@mail.ru"`),
+						"pattern": json.RawMessage(`"^info@"`),
+					},
+					{
+						"inn":     json.RawMessage(`"66630031"`),
+						"email":   json.RawMessage(`"info@mailru"`),
+						"pattern": json.RawMessage(`"^admin@"`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"inn":     core.STRING_TYPE,
+					"email":   core.STRING_TYPE,
+					"pattern": core.STRING_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+					"formula_2": {
+						Version: 1,
+						Color:   GreenColor,
+						Result:  true,
+					},
+				},
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  false,
+					},
+					"formula_2": {
+						Version: 1,
+						Color:   GreenColor,
+						Result:  false,
+					},
+				},
+			},
+		},
+
+		{
+			name: "date functions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name: "formula_1",
+						Expression: `years_between(reg_date, date("2023-03-30")) = 1 ` +
+							`AND months_between(reg_date, date("2021-04-30")) = 1 ` +
+							`AND add_months(reg_date, 1) = date("2021-04-30") ` +
+							`AND days_between(reg_date, add_months(reg_date, 1)) = 30 ` +
+							`AND days_between(today(), add_days(today(), 0 - 3)) = 0 - 3 AND age(reg_date) >= 2 ` +
+							`AND month(report_date) = 12 AND day(report_date) = 31 ` +
+							`AND trunc(report_date, "quarter") = date("2021-10-01") AND year(trunc(report_date, "year")) = 2021`,
+						Color:    RedColor,
+						Version:  0,
+						IsEnable: true,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"reg_date":    json.RawMessage(`"2021-03-31"`),
+						"report_date": json.RawMessage(`"2021-12-31T22:30:00Z"`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"reg_date":    core.DATE_TYPE,
+					"report_date": core.DATE_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+				},
+			},
+		},
+
+		{
+			name: "null functions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name: "formula_1",
+						Expression: "coalesce(s2001, s1000, s6004) = 10 AND isnull(s2001) AND isnull(s1000) " +
+							"AND isnull(s6004) = false AND ifnull(s1000, 5) = 5",
+						Color:    RedColor,
+						Version:  0,
+						IsEnable: true,
+					},
+					{
+						Name:       "formula_2",
+						Expression: "s2001 > 0 OR true",
+						Color:      RedColor,
+						Version:    1,
+						IsEnable:   true,
+					},
+					{
+						Name:       "score",
+						Expression: "s1000 * 2",
+						Color:      GreenColor,
+						Version:    2,
+						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
+					},
+					{
+						Name:       "score_or_default",
+						Expression: "ifnull(@score, 0) + 1",
+						Color:      GreenColor,
+						Version:    3,
+						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"s2001": json.RawMessage(`null`),
+						"s6004": json.RawMessage(`10`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"s2001": core.NUMBER_TYPE,
+					"s6004": core.NUMBER_TYPE,
+					"s1000": core.NUMBER_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+					"formula_2": {
+						Version: 1,
+						Color:   RedColor,
+						Result:  false,
+					},
+					"score": {
+						Version: 2,
+						Color:   GreenColor,
+					},
+					"score_or_default": {
+						Version: 3,
+						Color:   GreenColor,
+						Number:  floatPtr(1),
+					},
+				},
+			},
+		},
+
+		{
+			name: "'exists', 'missing' and 'empty' functions",
+			args: args{
+				formulas: []Formula{
+					{
+						Name: "formula_1",
+						Expression: "exists(5) AND exists(company.founder.inn) AND exists(company.founder.url) = false " +
+							"AND exists(s2001) = false AND missing(s2001) AND missing(s1000) AND exists(s6004 / s1000) = false " +
+							"AND empty(s2001) AND empty(name) AND empty(branches) AND empty(company.founder.inn) = false",
+						Color:    RedColor,
+						Version:  0,
+						IsEnable: true,
+					},
+				},
+				knownParams: []jparser.RawMessageSet{
+					{
+						"s2001":    json.RawMessage(`null`),
+						"s6004":    json.RawMessage(`10`),
+						"name":     json.RawMessage(`""`),
+						"branches": json.RawMessage(`[]`),
+						"company":  json.RawMessage(`{"founder": {"inn": "6663003127"}}`),
+					},
+				},
+				paramTypes: map[string]core.ValueType{
+					"s2001": core.NUMBER_TYPE,
+					"s6004": core.NUMBER_TYPE,
+					"s1000": core.NUMBER_TYPE,
+					"name":  core.STRING_TYPE,
+				},
+			},
+			expectedColor: RedColor,
+			expectedRes: []FormulaResult{
+				{
+					"formula_1": {
+						Version: 0,
+						Color:   RedColor,
+						Result:  true,
+					},
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -493,12 +938,63 @@ func TestCalculateErrors(t *testing.T) {
 		args args
 	}{
 		{
-			name: "func exists with num",
+			name: "only arithmetic expression",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "5 + 6 / s6004",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+		},
+
+		{
+			name: "wrong number of brackets",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "exists(s2001 = false",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+		},
+
+		{
+			name: "different type of operands",
 			args: args{
 				formulas: []Formula{
 					{
 						Name:       "formula_1",
-						Expression: "exists(5)",
+						Expression: "bool_param - 1 > 0",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+		},
+
+		{
+			name: "duplicate local variable",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "let x = 1, x = 2 in x > 0",
 						Color:      GreenColor,
 						Version:    0,
 						IsEnable:   true,
@@ -510,15 +1006,57 @@ func TestCalculateErrors(t *testing.T) {
 		},
 
 		{
-			name: "only arithmetic expression",
+			name: "let without body",
 			args: args{
 				formulas: []Formula{
 					{
 						Name:       "formula_1",
-						Expression: "5 + 6 / s6004",
+						Expression: "let x = 1 in",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: nil,
+				paramTypes:  nil,
+			},
+		},
+
+		{
+			name: "reference to disabled formula",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "@formula_2",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+					{
+						Name:       "formula_2",
+						Expression: "true",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   false,
+					},
+				},
+				knownParams: nil,
+				paramTypes:  nil,
+			},
+		},
+
+		{
+			name: "numeric formula with bool result",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: "s2001 > 0",
 						Color:      GreenColor,
 						Version:    0,
 						IsEnable:   true,
+						ResultType: core.NUMBER_TYPE,
 					},
 				},
 				knownParams: paramsWithOneElement,
@@ -527,12 +1065,12 @@ func TestCalculateErrors(t *testing.T) {
 		},
 
 		{
-			name: "wrong number of brackets",
+			name: "unknown function",
 			args: args{
 				formulas: []Formula{
 					{
 						Name:       "formula_1",
-						Expression: "exists(s2001 = false",
+						Expression: "integer(s2001) > 0",
 						Color:      GreenColor,
 						Version:    0,
 						IsEnable:   true,
@@ -544,12 +1082,63 @@ func TestCalculateErrors(t *testing.T) {
 		},
 
 		{
-			name: "different type of operands",
+			name: "wrong number of arguments",
 			args: args{
 				formulas: []Formula{
 					{
 						Name:       "formula_1",
-						Expression: "bool_param - 1 > 0",
+						Expression: "number(s2001, s6004) > 0",
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: paramsWithOneElement,
+				paramTypes:  types,
+			},
+		},
+
+		{
+			name: "failed conversion",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: `number("15 00") > 0`,
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: nil,
+				paramTypes:  nil,
+			},
+		},
+
+		{
+			name: "unterminated string",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: `okved = "64`,
+						Color:      GreenColor,
+						Version:    0,
+						IsEnable:   true,
+					},
+				},
+				knownParams: nil,
+				paramTypes:  nil,
+			},
+		},
+
+		{
+			name: "coalesce with different types",
+			args: args{
+				formulas: []Formula{
+					{
+						Name:       "formula_1",
+						Expression: `coalesce(s2001, "0") = "0"`,
 						Color:      GreenColor,
 						Version:    0,
 						IsEnable:   true,
@@ -583,6 +1172,71 @@ func TestCalculateErrors(t *testing.T) {
 	}
 }
 
+func TestCalculateFormulaCycle(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "@formula_2", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "@formula_3 OR true", Color: GreenColor, IsEnable: true},
+		{Name: "formula_3", Expression: "@formula_1", Color: GreenColor, IsEnable: true},
+	}
+
+	_, _, err := Calculate(formulas, nil, nil)
+
+	var cycleErr *FormulaCycleError
+	if !errors.As(err, &cycleErr) {
+		t.Fatalf("Calculate() got error = \"%v\", expected FormulaCycleError", err)
+	}
+
+	expected := []string{"formula_1", "formula_2", "formula_3", "formula_1"}
+	if !reflect.DeepEqual(cycleErr.Cycle, expected) {
+		t.Errorf("Calculate() got cycle = %v, expected = %v", cycleErr.Cycle, expected)
+	}
+}
+
+func TestCalculateArgumentTypes(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: `startsWith(s2001, "64")`, Color: GreenColor, IsEnable: true},
+	}
+
+	_, _, err := Calculate(formulas, paramsWithOneElement, types)
+
+	var argErr *core.ArgumentTypeError
+	if !errors.As(err, &argErr) {
+		t.Fatalf("Calculate() got error = \"%v\", expected ArgumentTypeError", err)
+	}
+
+	expected := core.ArgumentTypeError{Function: "startsWith", Arg: 1, Expected: core.STRING_TYPE, Got: core.NUMBER_TYPE}
+	if *argErr != expected {
+		t.Errorf("Calculate() got error = %+v, expected = %+v", *argErr, expected)
+	}
+}
+
+func TestCalculateInvalidPattern(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "inn matches `^[0-9`", Color: GreenColor, IsEnable: true},
+	}
+
+	_, _, err := Calculate(formulas, nil, map[string]core.ValueType{"inn": core.STRING_TYPE})
+
+	var patternErr *core.PatternError
+	if !errors.As(err, &patternErr) {
+		t.Fatalf("Calculate() got error = \"%v\", expected PatternError", err)
+	}
+
+	if patternErr.Position != 12 {
+		t.Errorf("Calculate() got position = %d, expected = 12", patternErr.Position)
+	}
+}
+
+func floatPtr(value float64) *float64 {
+	return &value
+}
+
 var (
 	paramsWithOneElement, _ = jparser.ParseParams(
 		json.RawMessage(`
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"strconv"
+)
+
+type opcode uint8
+
+const (
+	// opConst pushes the constant consts[arg]
+	opConst opcode = iota
+	// opParam pushes the parameter params[arg], it is looked up once per evaluation
+	opParam
+	// opRef pushes the result of the formula or of the shared subexpression refs[arg]
+	opRef
+	// opLocal pushes the local variable locals[arg]
+	opLocal
+	// opStore pops the value of the local variable locals[arg]
+	opStore
+	// opCall calls the function funcs[arg] with 'args' arguments from the stack
+	opCall
+	opOr
+	opAnd
+	opEqual
+	opNotEqual
+	opMatches
+	opMore
+	opLess
+	opMoreEqual
+	opLessEqual
+	opAdd
+	opSub
+	opMul
+	opDiv
+)
+
+var operatorOpcodes = map[string]opcode{
+	"OR":      opOr,
+	"AND":     opAnd,
+	"=":       opEqual,
+	"!=":      opNotEqual,
+	"matches": opMatches,
+	">":       opMore,
+	"<":       opLess,
+	">=":      opMoreEqual,
+	"<=":      opLessEqual,
+	"+":       opAdd,
+	"-":       opSub,
+	"*":       opMul,
+	"/":       opDiv,
+}
+
+// operatorNames are values of operator tokens by opcodes
+var operatorNames = func() map[opcode]string {
+	res := make(map[opcode]string, len(operatorOpcodes))
+	for name, op := range operatorOpcodes {
+		res[op] = name
+	}
+
+	return res
+}()
+
+type instruction struct {
+	op   opcode
+	arg  int
+	args int
+}
+
+type param struct {
+	name      string
+	valueType ValueType
+}
+
+// Bytecode is the postfix expression compiled for the VM: operators are opcodes, literals are parsed
+// into the constant pool and parameters, formula references and local variables are resolved to slots.
+// It doesn't change after compilation and can be evaluated concurrently.
+type Bytecode struct {
+	code   []instruction
+	consts []value
+	params []param
+	refs   []Token
+	funcs  []string
+	locals int
+	// stack is the maximum depth of the stack
+	stack int
+}
+
+// CompileBytecode compiles the postfix expression, e.g. the result of ToPostfixExp
+func CompileBytecode(tokens []Token) (*Bytecode, error) {
+	var (
+		res    = &Bytecode{code: make([]instruction, 0, len(tokens))}
+		params = map[string]int{}
+		refs   = map[string]int{}
+		funcs  = map[string]int{}
+		locals = map[string]int{}
+		depth  int
+	)
+
+	slot := func(slots map[string]int, name string, add func()) int {
+		i, ok := slots[name]
+		if !ok {
+			i = len(slots)
+			slots[name] = i
+			add()
+		}
+
+		return i
+	}
+
+	for _, token := range tokens {
+		var ins instruction
+
+		switch token.Type {
+		case NUMBER, BOOL, STRING, DATE, NULL:
+			ins = instruction{op: opConst, arg: len(res.consts)}
+			res.consts = append(res.consts, constValue(token))
+			depth++
+		case IDENT:
+			if i, ok := locals[token.Value]; ok {
+				ins = instruction{op: opLocal, arg: i}
+			} else {
+				ins = instruction{op: opParam, arg: slot(params, token.Value, func() {
+					res.params = append(res.params, param{name: token.Value, valueType: token.ValueType})
+				})}
+			}
+			depth++
+		case FORMULA_REF, SHARED:
+			ins = instruction{op: opRef, arg: slot(refs, string(token.Type)+":"+token.Value, func() {
+				res.refs = append(res.refs, token)
+			})}
+			depth++
+		case ASSIGN:
+			// A local variable hides the parameter with the same name only after the assignment
+			ins = instruction{op: opStore, arg: slot(locals, token.Value, func() { res.locals++ })}
+			depth--
+		case LOG_OP, COMP_OP, ARITH_OP:
+			op, ok := operatorOpcodes[token.Value]
+			if !ok {
+				return nil, &CalculationError{Reason: errUnknownToken, Value: token.Value}
+			}
+
+			ins = instruction{op: op}
+			depth--
+		case FUNC:
+			if !IsFunction(token.Value) {
+				return nil, &CalculationError{Reason: errUnknownToken, Value: token.Value}
+			}
+
+			ins = instruction{op: opCall, arg: slot(funcs, token.Value, func() {
+				res.funcs = append(res.funcs, token.Value)
+			}), args: token.Args}
+			depth -= token.Args - 1
+		default:
+			return nil, &UnknownTokenTypeError{TokenType: token.Type}
+		}
+
+		res.code = append(res.code, ins)
+
+		if depth > res.stack {
+			res.stack = depth
+		}
+	}
+
+	return res, nil
+}
+
+// Params returns names of parameters of the expression
+func (b *Bytecode) Params() []string {
+	res := make([]string, 0, len(b.params))
+	for _, param := range b.params {
+		res = append(res, param.name)
+	}
+
+	return res
+}
+
+// Refs returns names of formulas referenced by the expression
+func (b *Bytecode) Refs() []string {
+	res := make([]string, 0, len(b.refs))
+	for _, ref := range b.refs {
+		if ref.Type == FORMULA_REF {
+			res = append(res, ref.Value)
+		}
+	}
+
+	return res
+}
+
+func constValue(token Token) value {
+	res := tokenValue(token)
+
+	if token.ValueType == NUMBER_TYPE {
+		// A literal which isn't a number fails operators like in the interpreter
+		if number, err := strconv.ParseFloat(token.Value, 64); err == nil {
+			res.num, res.hasNum = number, true
+		}
+	}
+
+	return res
+}
@@ -1,19 +1,42 @@
 package core
 
 import (
+	"context"
+
 	"github.com/egelis/jparser"
 )
 
 func Calculate(tokens []Token, knownParams jparser.RawMessageSet) (Token, error) {
+	return CalculateContext(context.Background(), tokens, knownParams)
+}
+
+// CalculateContext calculates the expression like Calculate, but stops with ctx.Err() when the context is done
+func CalculateContext(ctx context.Context, tokens []Token, knownParams jparser.RawMessageSet) (Token, error) {
 	exp, err := ToPostfixExp(tokens)
 	if err != nil {
 		return Token{}, err
 	}
 
-	res, err := Evaluate(exp, knownParams)
+	res, err := EvaluateContext(ctx, exp, knownParams)
 	if err != nil {
 		return Token{}, err
 	}
 
 	return res, nil
 }
+
+// CalculateTrace calculates the expression like Calculate and also returns traces of its evaluation
+func CalculateTrace(tokens []Token, knownParams jparser.RawMessageSet) (Token, []*Trace, error) {
+	return CalculateWithOptions(context.Background(), tokens, RawSource(knownParams), Options{Trace: true})
+}
+
+// CalculateWithOptions calculates the expression like CalculateContext, see EvaluateWithOptions
+func CalculateWithOptions(ctx context.Context, tokens []Token, source ParamSource, opts Options,
+) (Token, []*Trace, error) {
+	exp, err := ToPostfixExp(tokens)
+	if err != nil {
+		return Token{}, nil, err
+	}
+
+	return EvaluateWithOptions(ctx, exp, source, opts)
+}
@@ -11,7 +11,16 @@ const (
 	NUMBER      TokenType = "number"
 	BOOL        TokenType = "boolWord"
 	IDENT       TokenType = "identificator"
-	EXISTS_FUNC TokenType = "existsFunc"
+	LET         TokenType = "let"
+	IN          TokenType = "in"
+	COMMA       TokenType = "comma"
+	ASSIGN      TokenType = "assign"
+	FORMULA_REF TokenType = "formulaRef"
+	STRING      TokenType = "string"
+	DATE        TokenType = "date"
+	FUNC        TokenType = "function"
+	NULL        TokenType = "null"
+	SHARED      TokenType = "shared" // a subexpression shared by formulas, see ShareSubexpressions
 )
 
 type ValueType string
@@ -19,12 +28,19 @@ type ValueType string
 const (
 	NUMBER_TYPE  ValueType = "number"
 	BOOL_TYPE    ValueType = "bool"
+	STRING_TYPE  ValueType = "string"
+	DATE_TYPE    ValueType = "date"
+	ARRAY_TYPE   ValueType = "array"
+	NULL_TYPE    ValueType = "null"
 	UNKNOWN_TYPE ValueType = "unknown"
-	// DATE etc.
 )
 
 type Token struct {
 	Type      TokenType
 	Value     string
 	ValueType ValueType
+	// Args is the number of arguments of a FUNC token
+	Args int
+	// Pos is the position of the token in the expression
+	Pos int
 }
@@ -0,0 +1,192 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const errConversion = "conversion not defined"
+
+// numberFunc converts a numeric string, a bool (1 or 0) or a number to a number
+func numberFunc(_ *env, args []Token) (*Token, error) {
+	x := args[0]
+
+	switch x.ValueType {
+	case NUMBER_TYPE, STRING_TYPE, UNKNOWN_TYPE:
+		number, ok := parseNumber(x.Value)
+		if !ok {
+			return nil, typeCastError(x.Value, NUMBER_TYPE)
+		}
+
+		return numberToken(number), nil
+	case BOOL_TYPE:
+		value, err := strconv.ParseBool(x.Value)
+		if err != nil {
+			return nil, typeCastError(x.Value, BOOL_TYPE)
+		}
+
+		if value {
+			return numberToken(1), nil
+		}
+
+		return numberToken(0), nil
+	default:
+		return nil, conversionError(x.ValueType, NUMBER_TYPE)
+	}
+}
+
+// stringFunc converts any value to a string, numbers lose trailing zeros and dates are formatted in RFC 3339
+func stringFunc(env *env, args []Token) (*Token, error) {
+	x := args[0]
+	value := x.Value
+
+	switch x.ValueType {
+	case NUMBER_TYPE:
+		number, ok := parseNumber(x.Value)
+		if !ok {
+			return nil, typeCastError(x.Value, NUMBER_TYPE)
+		}
+
+		value = strconv.FormatFloat(number, 'f', -1, 64)
+	case DATE_TYPE:
+		date, err := dateArg(env, x)
+		if err != nil {
+			return nil, err
+		}
+
+		value = date.Format(time.RFC3339)
+	}
+
+	return stringToken(value), nil
+}
+
+// boolFunc converts a string accepted by strconv.ParseBool or a number (true unless 0) to a bool
+func boolFunc(_ *env, args []Token) (*Token, error) {
+	x := args[0]
+
+	switch x.ValueType {
+	case BOOL_TYPE, STRING_TYPE, UNKNOWN_TYPE:
+		value, err := strconv.ParseBool(strings.TrimSpace(x.Value))
+		if err != nil {
+			return nil, typeCastError(x.Value, BOOL_TYPE)
+		}
+
+		return boolToken(value), nil
+	case NUMBER_TYPE:
+		number, ok := parseNumber(x.Value)
+		if !ok {
+			return nil, typeCastError(x.Value, NUMBER_TYPE)
+		}
+
+		return boolToken(number != 0), nil
+	default:
+		return nil, conversionError(x.ValueType, BOOL_TYPE)
+	}
+}
+
+// dateFunc converts a string to a date using the Go layout from the second argument,
+// by default one of ISO 8601 layouts is used. Dates without a time zone are in the time zone of the evaluation.
+func dateFunc(env *env, args []Token) (*Token, error) {
+	x := args[0]
+
+	switch x.ValueType {
+	case DATE_TYPE, STRING_TYPE, UNKNOWN_TYPE:
+	default:
+		return nil, conversionError(x.ValueType, DATE_TYPE)
+	}
+
+	if len(args) == 1 {
+		date, ok := parseDateIn(strings.TrimSpace(x.Value), env.loc)
+		if !ok {
+			return nil, typeCastError(x.Value, DATE_TYPE)
+		}
+
+		return dateToken(date), nil
+	}
+
+	date, err := time.ParseInLocation(args[1].Value, strings.TrimSpace(x.Value), env.loc)
+	if err != nil {
+		return nil, typeCastError(x.Value, DATE_TYPE)
+	}
+
+	return dateToken(date), nil
+}
+
+// coerceOperands converts strings and untyped parameters to the type of the other operand if possible.
+// It is used for operators in the lenient mode.
+func coerceOperands(env *env, x, y Token) (Token, Token) {
+	if x.ValueType == y.ValueType {
+		return x, y
+	}
+
+	if isUntyped(x) && isUntyped(y) {
+		x, y = coerce(env, x, STRING_TYPE), coerce(env, y, STRING_TYPE)
+	} else if isUntyped(x) {
+		x = coerce(env, x, y.ValueType)
+	} else if isUntyped(y) {
+		y = coerce(env, y, x.ValueType)
+	}
+
+	return x, y
+}
+
+func isUntyped(token Token) bool {
+	return token.ValueType == STRING_TYPE || token.ValueType == UNKNOWN_TYPE
+}
+
+func coerce(env *env, token Token, valueType ValueType) Token {
+	var (
+		converted *Token
+		err       error
+	)
+
+	switch valueType {
+	case NUMBER_TYPE:
+		converted, err = numberFunc(env, []Token{token})
+	case BOOL_TYPE:
+		converted, err = boolFunc(env, []Token{token})
+	case DATE_TYPE:
+		converted, err = dateFunc(env, []Token{token})
+	case STRING_TYPE:
+		converted, err = stringFunc(env, []Token{token})
+	default:
+		return token
+	}
+
+	if err != nil {
+		return token
+	}
+
+	return *converted
+}
+
+func parseNumber(value string) (float64, bool) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+
+	return number, err == nil
+}
+
+func numberToken(number float64) *Token {
+	return &Token{Type: NUMBER, Value: strconv.FormatFloat(number, 'f', -1, 64), ValueType: NUMBER_TYPE}
+}
+
+func boolToken(value bool) *Token {
+	return &Token{Type: BOOL, Value: strconv.FormatBool(value), ValueType: BOOL_TYPE}
+}
+
+func dateToken(date time.Time) *Token {
+	return &Token{Type: DATE, Value: date.Format(dateLayouts[0]), ValueType: DATE_TYPE}
+}
+
+func typeCastError(value string, valueType ValueType) error {
+	return &CalculationError{
+		Reason: errTypeCast,
+		Value:  fmt.Sprintf("'%s' failed cast to '%s'", value, valueType),
+	}
+}
+
+func conversionError(from, to ValueType) error {
+	return &CalculationError{Reason: errConversion, Value: fmt.Sprintf("'%s' to '%s'", from, to)}
+}
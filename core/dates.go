@@ -0,0 +1,218 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// dateLayouts are ISO 8601 layouts of dates accepted in parameters, the first one is used for output
+// nolint:gochecknoglobals
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// ParseDate parses a date in one of ISO 8601 layouts: "2006-01-02", "2006-01-02T15:04:05" or RFC 3339.
+// Dates without a time zone are in UTC.
+func ParseDate(value string) (time.Time, bool) {
+	return parseDateIn(value, time.UTC)
+}
+
+func parseDateIn(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range dateLayouts {
+		if date, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return date, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+const errDateUnit = "unknown date unit"
+
+// dateArg parses the date in the time zone of the evaluation
+func dateArg(env *env, token Token) (time.Time, error) {
+	date, ok := parseDateIn(token.Value, env.loc)
+	if !ok {
+		return time.Time{}, typeCastError(token.Value, DATE_TYPE)
+	}
+
+	return date.In(env.loc), nil
+}
+
+// todayFunc returns the start of the current day
+func todayFunc(env *env, _ []Token) (*Token, error) {
+	return dateToken(truncDay(env.now)), nil
+}
+
+func yearFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(date.Year())), nil
+}
+
+func monthFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(date.Month())), nil
+}
+
+func dayFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(date.Day())), nil
+}
+
+// daysBetweenFunc returns the number of calendar days from the first date to the second one
+func daysBetweenFunc(env *env, args []Token) (*Token, error) {
+	from, to, err := dateArgs(env, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Dates are moved to UTC to count days without daylight saving time shifts
+	fromDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDay := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+
+	return numberToken(math.Round(toDay.Sub(fromDay).Hours() / 24)), nil
+}
+
+// monthsBetweenFunc returns the number of whole months from the first date to the second one
+func monthsBetweenFunc(env *env, args []Token) (*Token, error) {
+	from, to, err := dateArgs(env, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(monthsBetween(from, to))), nil
+}
+
+// yearsBetweenFunc returns the number of whole years from the first date to the second one
+func yearsBetweenFunc(env *env, args []Token) (*Token, error) {
+	from, to, err := dateArgs(env, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(monthsBetween(from, to) / 12)), nil
+}
+
+// ageFunc returns the number of whole years from the date to today
+func ageFunc(env *env, args []Token) (*Token, error) {
+	from, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return numberToken(float64(monthsBetween(from, env.now) / 12)), nil
+}
+
+func addDaysFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := parseInt(args[1].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return dateToken(date.AddDate(0, 0, days)), nil
+}
+
+// addMonthsFunc adds months to the date, the day is limited by the last day of the resulting month
+func addMonthsFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	months, err := parseInt(args[1].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return dateToken(addMonths(date, months)), nil
+}
+
+// truncFunc returns the start of the day, the month, the quarter or the year of the date
+func truncFunc(env *env, args []Token) (*Token, error) {
+	date, err := dateArg(env, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	year, month, _ := date.Date()
+
+	switch unit := args[1].Value; unit {
+	case "day":
+		return dateToken(truncDay(date)), nil
+	case "month":
+		return dateToken(time.Date(year, month, 1, 0, 0, 0, 0, date.Location())), nil
+	case "quarter":
+		month -= (month - 1) % 3
+
+		return dateToken(time.Date(year, month, 1, 0, 0, 0, 0, date.Location())), nil
+	case "year":
+		return dateToken(time.Date(year, time.January, 1, 0, 0, 0, 0, date.Location())), nil
+	default:
+		return nil, &CalculationError{Reason: errDateUnit, Value: fmt.Sprintf("'%s'", unit)}
+	}
+}
+
+func dateArgs(env *env, args []Token) (from, to time.Time, err error) {
+	if from, err = dateArg(env, args[0]); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if to, err = dateArg(env, args[1]); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return from, to, nil
+}
+
+func truncDay(date time.Time) time.Time {
+	year, month, day := date.Date()
+
+	return time.Date(year, month, day, 0, 0, 0, 0, date.Location())
+}
+
+// monthsBetween counts whole months like addMonths, e.g. from 31 January to 28 February is 1 month
+func monthsBetween(from, to time.Time) int {
+	if to.Before(from) {
+		return -monthsBetween(to, from)
+	}
+
+	months := (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+	if addMonths(from, months).After(to) {
+		months--
+	}
+
+	return months
+}
+
+func addMonths(date time.Time, months int) time.Time {
+	year, month, day := date.Date()
+
+	// The first day of the resulting month is always valid
+	first := time.Date(year, month+time.Month(months), 1, 0, 0, 0, 0, date.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+
+	return time.Date(first.Year(), first.Month(), day,
+		date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), date.Location())
+}
@@ -1,7 +1,9 @@
 package core
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/egelis/jparser"
 )
@@ -14,11 +16,18 @@ const (
 	errDivisionByZero         = "division by zero"
 )
 
+// UnknownParameterError is returned when a missing or a null parameter is used not in null functions
 type UnknownParameterError struct {
 	Param string
+	// Null is set if the parameter is present, but its value is null
+	Null bool
 }
 
 func (e *UnknownParameterError) Error() string {
+	if e.Null {
+		return fmt.Sprintf("parameter is null: %s", e.Param)
+	}
+
 	return fmt.Sprintf("parameter not found: %s", e.Param)
 }
 
@@ -31,10 +40,88 @@ func (e *CalculationError) Error() string {
 	return fmt.Sprintf("%s: %s", e.Reason, e.Value)
 }
 
+// ParamSourceError is returned when ParamSource fails to look up a parameter
+type ParamSourceError struct {
+	Param string
+	Err   error
+}
+
+func (e *ParamSourceError) Error() string {
+	return fmt.Sprintf("lookup of parameter '%s' failed: %s", e.Param, e.Err)
+}
+
+func (e *ParamSourceError) Unwrap() error {
+	return e.Err
+}
+
+// Options tune the evaluation, the zero value means no traces
+type Options struct {
+	// Trace enables recording of traces of the evaluation
+	Trace bool
+	// Lenient converts operands of operators to the same type if possible, e.g. the string "1500" to a number
+	Lenient bool
+	// Location is the time zone of dates without a zone and of date functions, UTC by default
+	Location *time.Location
+	// Now is the time of today(), the current time by default
+	Now time.Time
+	// Patterns are compiled by CompilePatterns, other patterns are compiled on each use
+	Patterns Patterns
+	// Refs resolves FORMULA_REF tokens, they are traced by names of referenced formulas
+	Refs RefResolver
+}
+
+// ctxCheckInterval is the number of tokens evaluated between checks of the context
+const ctxCheckInterval = 64
+
 func Evaluate(tokens []Token, knownParams jparser.RawMessageSet) (Token, error) {
+	res, _, err := EvaluateWithOptions(context.Background(), tokens, RawSource(knownParams), Options{})
+
+	return res, err
+}
+
+// EvaluateContext evaluates the expression like Evaluate, but stops with ctx.Err() when the context is done
+func EvaluateContext(ctx context.Context, tokens []Token, knownParams jparser.RawMessageSet) (Token, error) {
+	res, _, err := EvaluateWithOptions(ctx, tokens, RawSource(knownParams), Options{})
+
+	return res, err
+}
+
+// EvaluateTrace evaluates the expression like Evaluate and also returns
+// traces of local variables followed by the trace of the whole expression
+func EvaluateTrace(tokens []Token, knownParams jparser.RawMessageSet) (Token, []*Trace, error) {
+	return EvaluateWithOptions(context.Background(), tokens, RawSource(knownParams), Options{Trace: true})
+}
+
+// EvaluateWithOptions evaluates the expression like EvaluateContext taking parameters from 'source'.
+// Traces are returned only if opts.Trace is set.
+func EvaluateWithOptions(ctx context.Context, tokens []Token, source ParamSource, opts Options,
+) (Token, []*Trace, error) {
+	var t *tracer
+	if opts.Trace {
+		t = &tracer{}
+	}
+
+	res, err := evaluate(ctx, tokens, source, t, opts)
+	if err != nil {
+		return Token{}, nil, err
+	}
+
+	return res, t.result(), nil
+}
+
+func evaluate(ctx context.Context, tokens []Token, source ParamSource, t *tracer, opts Options,
+) (Token, error) {
 	resStack := make(TokenStack, 0, len(tokens))
+	locals := map[string]Token{}
+	env := newEnv(opts)
+
+	for i, token := range tokens {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return Token{}, err
+			}
+		}
 
-	for _, token := range tokens {
 		switch token.Type {
 		case LOG_OP, COMP_OP, ARITH_OP:
 			opFunc, ok := operatorFuncs[token.Value]
@@ -45,26 +132,99 @@ func Evaluate(tokens []Token, knownParams jparser.RawMessageSet) (Token, error)
 			y, _ := resStack.Pop()
 			x, _ := resStack.Pop()
 
-			res, err := opFunc(x, y)
+			// Nulls propagate up to the result or to a null function like exists
+			if null, ok := firstNull(x, y); ok {
+				resStack.Push(null)
+				t.operator(token.Value, null)
+
+				continue
+			}
+
+			if opts.Lenient {
+				x, y = coerceOperands(env, x, y)
+			}
+
+			res, err := opFunc(env, x, y)
 			if err != nil {
 				return Token{}, err
 			}
 
 			resStack.Push(*res)
-		case NUMBER, BOOL:
+			t.operator(token.Value, *res)
+		case FUNC:
+			args := make([]Token, token.Args)
+			for j := len(args) - 1; j >= 0; j-- {
+				args[j], _ = resStack.Pop()
+			}
+
+			res, err := callFunction(env, token.Value, args)
+			if err != nil {
+				return Token{}, err
+			}
+
+			resStack.Push(*res)
+			t.call(token.Value, len(args), *res)
+		case NUMBER, BOOL, STRING, DATE:
 			resStack.Push(token)
+			t.literal(token)
+		case NULL:
+			resStack.Push(token)
+			t.namedOperand(token.Value, displayNull(token))
+		case FORMULA_REF:
+			if opts.Refs == nil {
+				return Token{}, &CalculationError{Reason: errUnknownToken, Value: token.Value}
+			}
+
+			ref, err := opts.Refs(token)
+			if err != nil {
+				return Token{}, err
+			}
+
+			resStack.Push(ref)
+			t.namedOperand("@"+token.Value, displayNull(ref))
+		case ASSIGN:
+			value, _ := resStack.Pop()
+			locals[token.Value] = value
+			t.assign(token.Value)
 		case IDENT:
-			rawValue, ok := knownParams[token.Value]
-			if !ok {
-				return Token{}, &UnknownParameterError{Param: token.Value}
+			if local, ok := locals[token.Value]; ok {
+				resStack.Push(local)
+				t.namedOperand(token.Value, displayNull(local))
+
+				continue
 			}
 
+			param, ok, err := source.Lookup(token.Value)
+			if err != nil {
+				return Token{}, &ParamSourceError{Param: token.Value, Err: err}
+			}
+
+			// A missing parameter is null, it fails the evaluation if it isn't handled by null functions
+			if !ok || param == nil {
+				null := Token{Type: NULL, Value: token.Value, ValueType: NULL_TYPE}
+				if !ok {
+					null.Type = IDENT
+				}
+
+				resStack.Push(null)
+				t.namedOperand(token.Value, displayNull(null))
+
+				continue
+			}
+
+			value, err := formatParam(token.Value, param)
+			if err != nil {
+				return Token{}, err
+			}
+
+			value = strictParam(token.ValueType, param, value, opts.Lenient)
+
 			resStack.Push(Token{
 				Type:      token.Type,
-				Value:     string(rawValue),
-				ValueType: token.ValueType,
+				Value:     value,
+				ValueType: paramValueType(token.ValueType, param),
 			})
-		// TODO: case DATE:
+			t.namedOperand(token.Value, value)
 		default:
 			return Token{}, &UnknownTokenTypeError{TokenType: token.Type}
 		}
@@ -73,6 +233,8 @@ func Evaluate(tokens []Token, knownParams jparser.RawMessageSet) (Token, error)
 	res, _ := resStack.Pop()
 
 	switch res.ValueType {
+	case NULL_TYPE:
+		return Token{}, nullError(res)
 	case BOOL_TYPE:
 		return Token{
 			Type:      BOOL,
@@ -85,6 +247,18 @@ func Evaluate(tokens []Token, knownParams jparser.RawMessageSet) (Token, error)
 			Value:     res.Value,
 			ValueType: NUMBER_TYPE,
 		}, nil
+	case STRING_TYPE:
+		return Token{
+			Type:      STRING,
+			Value:     res.Value,
+			ValueType: STRING_TYPE,
+		}, nil
+	case DATE_TYPE:
+		return Token{
+			Type:      DATE,
+			Value:     res.Value,
+			ValueType: DATE_TYPE,
+		}, nil
 	default:
 		return Token{}, &CalculationError{Reason: errUnknownToken, Value: res.Value}
 	}
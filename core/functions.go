@@ -0,0 +1,185 @@
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const errArgsCount = "wrong number of arguments"
+
+type ArgumentTypeError struct {
+	Function string
+	// Arg is the position of the argument starting from 1
+	Arg      int
+	Expected ValueType
+	Got      ValueType
+}
+
+func (e *ArgumentTypeError) Error() string {
+	return fmt.Sprintf("function '%s': argument %d must be '%s', got '%s'", e.Function, e.Arg, e.Expected, e.Got)
+}
+
+// function is a function which can be called from formulas
+type function struct {
+	// params are types of arguments, UNKNOWN_TYPE accepts any type.
+	// The last type is repeated for variadic functions.
+	params   []ValueType
+	minArgs  int
+	variadic bool
+	// result is UNKNOWN_TYPE if it depends on arguments
+	result ValueType
+	// sameType functions require arguments of the same type, which is also the type of the result
+	sameType bool
+	// nullable functions accept null values, others fail like operators
+	nullable bool
+	call     func(env *env, args []Token) (res *Token, err error)
+}
+
+var functions = map[string]function{
+	"number": {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: numberFunc},
+	"string": {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: STRING_TYPE, call: stringFunc},
+	"bool":   {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: BOOL_TYPE, call: boolFunc},
+	"date":   {params: []ValueType{UNKNOWN_TYPE, STRING_TYPE}, minArgs: 1, result: DATE_TYPE, call: dateFunc},
+
+	"len":        {params: []ValueType{STRING_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: lenFunc},
+	"upper":      {params: []ValueType{STRING_TYPE}, minArgs: 1, result: STRING_TYPE, call: upperFunc},
+	"lower":      {params: []ValueType{STRING_TYPE}, minArgs: 1, result: STRING_TYPE, call: lowerFunc},
+	"trim":       {params: []ValueType{STRING_TYPE}, minArgs: 1, result: STRING_TYPE, call: trimFunc},
+	"contains":   {params: []ValueType{STRING_TYPE, STRING_TYPE}, minArgs: 2, result: BOOL_TYPE, call: containsFunc},
+	"startsWith": {params: []ValueType{STRING_TYPE, STRING_TYPE}, minArgs: 2, result: BOOL_TYPE, call: startsWithFunc},
+	"regex":      {params: []ValueType{STRING_TYPE, STRING_TYPE}, minArgs: 2, result: BOOL_TYPE, call: regexFunc},
+	"substring": {
+		params:  []ValueType{STRING_TYPE, NUMBER_TYPE, NUMBER_TYPE},
+		minArgs: 2,
+		result:  STRING_TYPE,
+		call:    substringFunc,
+	},
+
+	"today":          {params: []ValueType{}, result: DATE_TYPE, call: todayFunc},
+	"year":           {params: []ValueType{DATE_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: yearFunc},
+	"month":          {params: []ValueType{DATE_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: monthFunc},
+	"day":            {params: []ValueType{DATE_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: dayFunc},
+	"age":            {params: []ValueType{DATE_TYPE}, minArgs: 1, result: NUMBER_TYPE, call: ageFunc},
+	"days_between":   {params: []ValueType{DATE_TYPE, DATE_TYPE}, minArgs: 2, result: NUMBER_TYPE, call: daysBetweenFunc},
+	"months_between": {params: []ValueType{DATE_TYPE, DATE_TYPE}, minArgs: 2, result: NUMBER_TYPE, call: monthsBetweenFunc},
+	"years_between":  {params: []ValueType{DATE_TYPE, DATE_TYPE}, minArgs: 2, result: NUMBER_TYPE, call: yearsBetweenFunc},
+	"add_days":       {params: []ValueType{DATE_TYPE, NUMBER_TYPE}, minArgs: 2, result: DATE_TYPE, call: addDaysFunc},
+	"add_months":     {params: []ValueType{DATE_TYPE, NUMBER_TYPE}, minArgs: 2, result: DATE_TYPE, call: addMonthsFunc},
+	"trunc":          {params: []ValueType{DATE_TYPE, STRING_TYPE}, minArgs: 2, result: DATE_TYPE, call: truncFunc},
+
+	"exists":  {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: BOOL_TYPE, nullable: true, call: existsFunc},
+	"missing": {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: BOOL_TYPE, nullable: true, call: isNullFunc},
+	"isnull":  {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: BOOL_TYPE, nullable: true, call: isNullFunc},
+	"empty":   {params: []ValueType{UNKNOWN_TYPE}, minArgs: 1, result: BOOL_TYPE, nullable: true, call: emptyFunc},
+	"coalesce": {
+		params:   []ValueType{UNKNOWN_TYPE},
+		minArgs:  1,
+		variadic: true,
+		result:   UNKNOWN_TYPE,
+		sameType: true,
+		nullable: true,
+		call:     coalesceFunc,
+	},
+	"ifnull": {
+		params:   []ValueType{UNKNOWN_TYPE, UNKNOWN_TYPE},
+		minArgs:  2,
+		result:   UNKNOWN_TYPE,
+		sameType: true,
+		nullable: true,
+		call:     coalesceFunc,
+	},
+}
+
+// IsFunction reports whether formulas can call the function 'name'
+func IsFunction(name string) bool {
+	_, ok := functions[name]
+
+	return ok
+}
+
+// env is the environment of the evaluation for functions
+type env struct {
+	loc *time.Location
+	// now is the same for the whole evaluation
+	now      time.Time
+	patterns Patterns
+}
+
+func newEnv(opts Options) *env {
+	res := &env{}
+	res.init(opts)
+
+	return res
+}
+
+// init sets the environment from options, the VM keeps it between evaluations
+func (e *env) init(opts Options) {
+	e.loc, e.now, e.patterns = opts.Location, opts.Now, opts.Patterns
+
+	if e.loc == nil {
+		e.loc = time.UTC
+	}
+
+	if e.now.IsZero() {
+		e.now = time.Now()
+	}
+
+	e.now = e.now.In(e.loc)
+}
+
+func callFunction(env *env, name string, args []Token) (*Token, error) {
+	fn, ok := functions[name]
+	if !ok {
+		return nil, &CalculationError{Reason: errUnknownToken, Value: name}
+	}
+
+	argTypes := make([]ValueType, 0, len(args))
+	for _, arg := range args {
+		argTypes = append(argTypes, arg.ValueType)
+	}
+
+	if _, err := fn.checkArgs(name, argTypes); err != nil {
+		return nil, err
+	}
+
+	if null, ok := firstNull(args...); ok && !fn.nullable {
+		return &null, nil
+	}
+
+	return fn.call(env, args)
+}
+
+// checkArgs checks the number and types of arguments and returns the type of the result.
+// Arguments of UNKNOWN_TYPE are checked by the function itself, null arguments are checked in callFunction.
+func (f function) checkArgs(name string, argTypes []ValueType) (ValueType, error) {
+	if len(argTypes) < f.minArgs || (!f.variadic && len(argTypes) > len(f.params)) {
+		return "", &CalculationError{Reason: errArgsCount, Value: fmt.Sprintf("%s: %d", name, len(argTypes))}
+	}
+
+	result := f.result
+
+	for i, argType := range argTypes {
+		if argType == UNKNOWN_TYPE || argType == NULL_TYPE {
+			continue
+		}
+
+		param := f.params[len(f.params)-1]
+		if i < len(f.params) {
+			param = f.params[i]
+		}
+
+		if f.sameType && result != UNKNOWN_TYPE {
+			param = result
+		}
+
+		if param != UNKNOWN_TYPE && argType != param {
+			return "", &ArgumentTypeError{Function: name, Arg: i + 1, Expected: param, Got: argType}
+		}
+
+		if f.sameType {
+			result = argType
+		}
+	}
+
+	return result, nil
+}
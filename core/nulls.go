@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// existsFunc checks that the value isn't null, e.g. the parameter is present and its value isn't JSON null
+func existsFunc(_ *env, args []Token) (*Token, error) {
+	return boolToken(args[0].ValueType != NULL_TYPE), nil
+}
+
+func isNullFunc(_ *env, args []Token) (*Token, error) {
+	return boolToken(args[0].ValueType == NULL_TYPE), nil
+}
+
+// coalesceFunc returns the first argument which is not null
+func coalesceFunc(_ *env, args []Token) (*Token, error) {
+	for _, arg := range args {
+		if arg.ValueType != NULL_TYPE {
+			return &arg, nil
+		}
+	}
+
+	return &args[len(args)-1], nil
+}
+
+// emptyFunc checks that the value is null, an empty string or an empty array
+func emptyFunc(_ *env, args []Token) (*Token, error) {
+	x := args[0]
+
+	switch x.ValueType {
+	case NULL_TYPE:
+		return boolToken(true), nil
+	case STRING_TYPE, UNKNOWN_TYPE:
+		return boolToken(x.Value == ""), nil
+	case ARRAY_TYPE:
+		var items []json.RawMessage
+		if err := json.Unmarshal([]byte(x.Value), &items); err != nil {
+			return nil, typeCastError(x.Value, ARRAY_TYPE)
+		}
+
+		return boolToken(len(items) == 0), nil
+	default:
+		return boolToken(false), nil
+	}
+}
+
+func firstNull(tokens ...Token) (Token, bool) {
+	for _, token := range tokens {
+		if token.ValueType == NULL_TYPE {
+			return token, true
+		}
+	}
+
+	return Token{}, false
+}
+
+// paramValueType is the declared type of the parameter, arrays of untyped parameters are recognized by the value
+func paramValueType(declared ValueType, value any) ValueType {
+	if declared != UNKNOWN_TYPE {
+		return declared
+	}
+
+	if _, ok := value.(json.RawMessage); ok {
+		return declared
+	}
+
+	switch reflect.ValueOf(value).Kind() { // nolint:exhaustive
+	case reflect.Slice, reflect.Array:
+		return ARRAY_TYPE
+	default:
+		return declared
+	}
+}
+
+// nullError is the error of a null result of the formula.
+// Tokens of missing parameters are IDENT, tokens of parameters with the null value are NULL.
+func nullError(token Token) error {
+	return &UnknownParameterError{Param: token.Value, Null: token.Type == NULL}
+}
+
+// displayNull is the value of the token for traces
+func displayNull(token Token) string {
+	if token.ValueType == NULL_TYPE {
+		return "null"
+	}
+
+	return token.Value
+}
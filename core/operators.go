@@ -3,26 +3,28 @@ package core
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
-type operatorFunc func(x, y Token) (res *Token, err error)
+type operatorFunc func(env *env, x, y Token) (res *Token, err error)
 
 var operatorFuncs = map[string]operatorFunc{
-	"OR":  orOperator,
-	"AND": andOperator,
-	"=":   equalOperator,
-	"!=":  notEqualOperator,
-	">":   moreOperator,
-	"<":   lessOperator,
-	">=":  moreEqualOperator,
-	"<=":  lessEqualOperator,
-	"+":   addOperator,
-	"-":   subOperator,
-	"*":   mulOperator,
-	"/":   divOperator,
+	"OR":      orOperator,
+	"AND":     andOperator,
+	"=":       equalOperator,
+	"!=":      notEqualOperator,
+	"matches": matchesOperator,
+	">":       moreOperator,
+	"<":       lessOperator,
+	">=":      moreEqualOperator,
+	"<=":      lessEqualOperator,
+	"+":       addOperator,
+	"-":       subOperator,
+	"*":       mulOperator,
+	"/":       divOperator,
 }
 
-func addOperator(x, y Token) (res *Token, err error) {
+func addOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -49,7 +51,7 @@ func addOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func subOperator(x, y Token) (res *Token, err error) {
+func subOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -76,7 +78,7 @@ func subOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func mulOperator(x, y Token) (res *Token, err error) {
+func mulOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -103,7 +105,7 @@ func mulOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func divOperator(x, y Token) (res *Token, err error) {
+func divOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -134,7 +136,7 @@ func divOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func lessEqualOperator(x, y Token) (res *Token, err error) {
+func lessEqualOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -155,13 +157,26 @@ func lessEqualOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", !op1.After(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
 	}
 }
 
-func moreEqualOperator(x, y Token) (res *Token, err error) {
+func moreEqualOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -182,13 +197,26 @@ func moreEqualOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", !op1.Before(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
 	}
 }
 
-func moreOperator(x, y Token) (res *Token, err error) {
+func moreOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -209,13 +237,26 @@ func moreOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", op1.After(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
 	}
 }
 
-func lessOperator(x, y Token) (res *Token, err error) {
+func lessOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -236,13 +277,26 @@ func lessOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", op1.Before(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
 	}
 }
 
-func orOperator(x, y Token) (res *Token, err error) {
+func orOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -269,7 +323,7 @@ func orOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func andOperator(x, y Token) (res *Token, err error) {
+func andOperator(_ *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -296,7 +350,7 @@ func andOperator(x, y Token) (res *Token, err error) {
 	}
 }
 
-func equalOperator(x, y Token) (res *Token, err error) {
+func equalOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -330,13 +384,34 @@ func equalOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == STRING_TYPE {
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", x.Value == y.Value),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", op1.Equal(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
 	}
 }
 
-func notEqualOperator(x, y Token) (res *Token, err error) {
+func notEqualOperator(env *env, x, y Token) (res *Token, err error) {
 	if x.ValueType != y.ValueType {
 		return nil, &CalculationError{
 			Reason: errDifferentType,
@@ -370,6 +445,27 @@ func notEqualOperator(x, y Token) (res *Token, err error) {
 		}, nil
 	}
 
+	if x.ValueType == STRING_TYPE {
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", x.Value != y.Value),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
+	if x.ValueType == DATE_TYPE {
+		op1, op2, err := parseDateOperands(env, x.Value, y.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Token{
+			Type:      BOOL,
+			Value:     fmt.Sprintf("%t", !op1.Equal(op2)),
+			ValueType: BOOL_TYPE,
+		}, nil
+	}
+
 	return nil, &CalculationError{
 		Reason: errInvalidOperatorForType,
 		Value:  fmt.Sprintf("'%s'", x.ValueType),
@@ -415,3 +511,18 @@ func parseFloatOperands(value1, value2 string) (op1, op2 float64, err error) {
 
 	return op1, op2, nil
 }
+
+// parseDateOperands parses dates in the time zone of the evaluation like date functions
+func parseDateOperands(env *env, value1, value2 string) (op1, op2 time.Time, err error) {
+	op1, ok := parseDateIn(value1, env.loc)
+	if !ok {
+		return time.Time{}, time.Time{}, typeCastError(value1, DATE_TYPE)
+	}
+
+	op2, ok = parseDateIn(value2, env.loc)
+	if !ok {
+		return time.Time{}, time.Time{}, typeCastError(value2, DATE_TYPE)
+	}
+
+	return op1, op2, nil
+}
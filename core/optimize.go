@@ -0,0 +1,228 @@
+package core
+
+import (
+	"strconv"
+)
+
+// operand is a subexpression of the postfix expression during optimization
+type operand struct {
+	tokens    []Token
+	valueType ValueType
+	// constant operands are literals of numbers, bools or strings
+	constant bool
+	// boolean operands are evaluated to a bool or null and never to a value of another type
+	boolean bool
+	// nullable operands may be evaluated to null, e.g. if they depend on parameters
+	nullable bool
+	// failing operands have a constant subexpression which fails, like '1 / 0'
+	failing bool
+	// falsy operands are evaluated to false or null, which is also false for the result of the formula
+	falsy bool
+}
+
+// Optimize folds constant subexpressions of the postfix expression and replaces 'true AND x' and 'false OR x'
+// (also with swapped operands) by 'x' if 'x' is a condition. 'false AND x' and 'true OR x' are replaced
+// by the literal if 'x' is a condition which is never null, 'false AND x' is also replaced by false
+// if it's the result of the expression, since a null result is false. Subexpressions which fail, like '1 / 0',
+// and date functions, which depend on the time zone and the current time, are left for the evaluation,
+// but errors of parameters in the dropped 'x' aren't reported.
+// The result is evaluated the same way as the original expression, but usually faster.
+func Optimize(tokens []Token) []Token {
+	var (
+		stack  []operand
+		res    []Token
+		locals = map[string]operand{}
+		env    = newEnv(Options{})
+	)
+
+	push := func(op operand) {
+		stack = append(stack, op)
+	}
+
+	pop := func() operand {
+		if len(stack) == 0 {
+			return operand{valueType: UNKNOWN_TYPE}
+		}
+
+		op := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		return op
+	}
+
+	for _, token := range tokens {
+		switch token.Type {
+		case NUMBER, BOOL, STRING:
+			push(literal(token))
+		case IDENT:
+			local, ok := locals[token.Value]
+			switch {
+			case !ok:
+				push(operand{tokens: []Token{token}, valueType: token.ValueType, nullable: true})
+			case local.constant:
+				push(local)
+			default:
+				push(operand{tokens: []Token{token}, valueType: local.valueType, boolean: local.boolean,
+					nullable: local.nullable, failing: local.failing, falsy: local.falsy})
+			}
+		case FORMULA_REF:
+			// References to conditions are false if the formula is null, scores without value are null
+			push(operand{tokens: []Token{token}, valueType: token.ValueType, boolean: token.ValueType == BOOL_TYPE,
+				nullable: token.ValueType != BOOL_TYPE})
+		case ASSIGN:
+			// Constant local variables are replaced by their values
+			value := pop()
+			locals[token.Value] = value
+
+			if !value.constant {
+				res = append(res, value.tokens...)
+				res = append(res, token)
+			}
+		case LOG_OP, COMP_OP, ARITH_OP:
+			y := pop()
+			x := pop()
+			push(foldOperator(env, token, x, y))
+		case FUNC:
+			args := make([]operand, token.Args)
+			for i := len(args) - 1; i >= 0; i-- {
+				args[i] = pop()
+			}
+
+			push(foldFunction(env, token, args))
+		default:
+			push(operand{tokens: []Token{token}, valueType: token.ValueType, nullable: token.Type == NULL})
+		}
+	}
+
+	result := pop()
+	if result.falsy && !result.constant {
+		return append(res, Token{Type: BOOL, Value: "false", ValueType: BOOL_TYPE, Pos: result.tokens[0].Pos})
+	}
+
+	return append(res, result.tokens...)
+}
+
+func literal(token Token) operand {
+	return operand{tokens: []Token{token}, valueType: token.ValueType, constant: true, boolean: token.Type == BOOL}
+}
+
+func foldOperator(env *env, token Token, x, y operand) operand {
+	// Lenient conversions don't change operands of the same type
+	if x.constant && y.constant && x.valueType == y.valueType {
+		res, err := operatorFuncs[token.Value](env, x.tokens[0], y.tokens[0])
+		if err == nil {
+			res.Pos = x.tokens[0].Pos
+
+			return literal(*res)
+		}
+	}
+
+	switch {
+	case token.Value == "AND" && isBoolLiteral(x, true) && y.boolean:
+		return y
+	case token.Value == "AND" && isBoolLiteral(y, true) && x.boolean:
+		return x
+	case token.Value == "OR" && isBoolLiteral(x, false) && y.boolean:
+		return y
+	case token.Value == "OR" && isBoolLiteral(y, false) && x.boolean:
+		return x
+	case token.Value == "AND" && isBoolLiteral(x, false) && isDroppable(y):
+		return x
+	case token.Value == "AND" && isBoolLiteral(y, false) && isDroppable(x):
+		return y
+	case token.Value == "OR" && isBoolLiteral(x, true) && isDroppable(y):
+		return x
+	case token.Value == "OR" && isBoolLiteral(y, true) && isDroppable(x):
+		return y
+	}
+
+	res := operand{valueType: BOOL_TYPE, boolean: true}
+	if token.Type == ARITH_OP {
+		res = operand{valueType: NUMBER_TYPE}
+	}
+
+	res.nullable = x.nullable || y.nullable
+	res.failing = x.failing || y.failing || x.constant && y.constant
+	// 'false AND x' is null if 'x' is null, it's replaced by false only as the result of the expression
+	res.falsy = token.Value == "AND" && !res.failing && x.boolean && y.boolean && (isFalsy(x) || isFalsy(y))
+
+	res.tokens = make([]Token, 0, len(x.tokens)+len(y.tokens)+1)
+	res.tokens = append(append(append(res.tokens, x.tokens...), y.tokens...), token)
+
+	return res
+}
+
+func foldFunction(env *env, token Token, args []operand) operand {
+	var (
+		argTypes = make([]ValueType, 0, len(args))
+		tokens   []Token
+		constant = true
+		nullable bool
+		failing  bool
+	)
+
+	for _, arg := range args {
+		argTypes = append(argTypes, arg.valueType)
+		tokens = append(tokens, arg.tokens...)
+		constant = constant && arg.constant
+		nullable = nullable || arg.nullable
+		failing = failing || arg.failing
+	}
+
+	resultType := UNKNOWN_TYPE
+	if fn, ok := functions[token.Value]; ok {
+		if valueType, err := fn.checkArgs(token.Value, argTypes); err == nil {
+			resultType = valueType
+		}
+
+		// Null functions like exists check nulls instead of returning them
+		if fn.nullable && resultType == BOOL_TYPE {
+			nullable = false
+		}
+	}
+
+	// Dates depend on the time zone and today() on the current time
+	if constant && resultType != DATE_TYPE {
+		if res, err := callFunction(env, token.Value, tokens); err == nil && isLiteral(*res) {
+			res.Pos = token.Pos
+
+			return literal(*res)
+		}
+	}
+
+	return operand{
+		tokens:    append(tokens, token),
+		valueType: resultType,
+		boolean:   resultType == BOOL_TYPE,
+		nullable:  nullable,
+		failing:   failing || constant,
+	}
+}
+
+func isLiteral(token Token) bool {
+	switch token.Type { // nolint:exhaustive
+	case NUMBER, BOOL, STRING:
+		return token.ValueType != NULL_TYPE
+	default:
+		return false
+	}
+}
+
+// isDroppable reports whether the condition can be dropped from 'false AND x' and 'true OR x'
+func isDroppable(op operand) bool {
+	return op.boolean && !op.nullable && !op.failing
+}
+
+func isFalsy(op operand) bool {
+	return op.falsy || isBoolLiteral(op, false)
+}
+
+func isBoolLiteral(op operand, value bool) bool {
+	if !op.constant || op.valueType != BOOL_TYPE {
+		return false
+	}
+
+	b, err := strconv.ParseBool(op.tokens[0].Value)
+
+	return err == nil && b == value
+}
@@ -5,19 +5,20 @@ import (
 )
 
 var operatorPrecedence = map[string]int{
-	"(":   10,
-	"OR":  20,
-	"AND": 30,
-	"=":   40,
-	"!=":  40,
-	">":   50,
-	"<":   50,
-	">=":  50,
-	"<=":  50,
-	"+":   120,
-	"-":   120,
-	"/":   130,
-	"*":   130,
+	"(":       10,
+	"OR":      20,
+	"AND":     30,
+	"=":       40,
+	"!=":      40,
+	"matches": 40,
+	">":       50,
+	"<":       50,
+	">=":      50,
+	"<=":      50,
+	"+":       120,
+	"-":       120,
+	"/":       130,
+	"*":       130,
 }
 
 type UnknownTokenTypeError struct {
@@ -37,10 +38,16 @@ func ToPostfixExp(infixExp []Token) ([]Token, error) {
 
 	for _, token := range infixExp {
 		switch token.Type {
-		case NUMBER, BOOL, IDENT:
+		case NUMBER, BOOL, STRING, DATE, NULL, IDENT, FORMULA_REF:
 			output = append(output, token)
-		case LBR:
+		case LBR, FUNC:
 			operationStack.Push(token)
+		case COMMA:
+			// The argument is complete, flush it up to the bracket of the function call
+			for operation, ok := operationStack.Peek(); ok && operation.Type != LBR; operation, ok = operationStack.Peek() {
+				operationStack.Pop()
+				output = append(output, operation)
+			}
 		case RBR:
 			operation, ok := operationStack.Pop()
 			for ok {
@@ -50,6 +57,11 @@ func ToPostfixExp(infixExp []Token) ([]Token, error) {
 				output = append(output, operation)
 				operation, ok = operationStack.Pop()
 			}
+
+			if function, ok := operationStack.Peek(); ok && function.Type == FUNC {
+				operationStack.Pop()
+				output = append(output, function)
+			}
 		case LOG_OP, COMP_OP, ARITH_OP:
 			if weight, ok := operatorPrecedence[token.Value]; ok {
 				stackOperation, ok := operationStack.Peek()
@@ -62,6 +74,12 @@ func ToPostfixExp(infixExp []Token) ([]Token, error) {
 
 				operationStack.Push(token)
 			}
+		case ASSIGN:
+			// The bound expression is complete, flush it before the assignment
+			for operation, ok := operationStack.Pop(); ok; operation, ok = operationStack.Pop() {
+				output = append(output, operation)
+			}
+			output = append(output, token)
 		default:
 			return nil, &UnknownTokenTypeError{TokenType: token.Type}
 		}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const errPattern = "invalid pattern"
+
+type PatternError struct {
+	Pattern  string
+	Position int
+	Err      error
+}
+
+func (e *PatternError) Error() string {
+	return fmt.Sprintf("%s at position %d: %s", errPattern, e.Position, e.Err)
+}
+
+func (e *PatternError) Unwrap() error {
+	return e.Err
+}
+
+// Patterns are compiled regular expressions by their source, see Options.Patterns
+type Patterns map[string]*regexp.Regexp
+
+// CompilePatterns compiles literal patterns of the 'matches' operator and of the 'regex' function
+// in the postfix expression and adds them to 'patterns', so they are compiled once instead of each evaluation.
+// Patterns calculated from parameters are compiled during the evaluation.
+func CompilePatterns(tokens []Token, patterns Patterns) error {
+	for i, token := range tokens {
+		// The last operand of an operator or a function is just before it
+		if i == 0 || !usesPattern(token) || tokens[i-1].Type != STRING {
+			continue
+		}
+
+		pattern := tokens[i-1]
+
+		re, err := regexp.Compile(pattern.Value)
+		if err != nil {
+			return &PatternError{Pattern: pattern.Value, Position: pattern.Pos, Err: err}
+		}
+
+		patterns[pattern.Value] = re
+	}
+
+	return nil
+}
+
+func usesPattern(token Token) bool {
+	return (token.Type == COMP_OP && token.Value == "matches") || (token.Type == FUNC && token.Value == "regex")
+}
+
+// matchPattern reports whether the string contains a match of the RE2 pattern
+func matchPattern(env *env, value, pattern string) (bool, error) {
+	if re, ok := env.patterns[pattern]; ok {
+		return re.MatchString(value), nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, &CalculationError{Reason: errPattern, Value: fmt.Sprintf("'%s': %s", pattern, err)}
+	}
+
+	return re.MatchString(value), nil
+}
+
+func matchesOperator(env *env, x, y Token) (res *Token, err error) {
+	if !isUntyped(x) || !isUntyped(y) {
+		return nil, &CalculationError{
+			Reason: errInvalidOperatorForType,
+			Value:  fmt.Sprintf("'%s' matches '%s'", x.ValueType, y.ValueType),
+		}
+	}
+
+	ok, err := matchPattern(env, x.Value, y.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(ok), nil
+}
+
+func regexFunc(env *env, args []Token) (*Token, error) {
+	ok, err := matchPattern(env, args[0].Value, args[1].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return boolToken(ok), nil
+}
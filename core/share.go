@@ -0,0 +1,219 @@
+package core
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Shared is a subexpression which occurs more than once in expressions, see ShareSubexpressions
+type Shared struct {
+	// Name is the value of SHARED tokens which replace the subexpression, e.g. "$1"
+	Name string
+	// Tokens are the postfix subexpression, they may contain SHARED tokens of preceding subexpressions
+	Tokens []Token
+}
+
+// node is an operand or an operator of the expression tree built from the postfix expression
+type node struct {
+	token    Token
+	children []*node
+	// key is the same for identical subexpressions
+	key string
+	// local nodes depend on local variables of the expression and can't be shared
+	local bool
+}
+
+// ShareSubexpressions finds identical subexpressions with operators or functions which occur more than once
+// in 'exps' and replaces them by SHARED tokens. Subexpressions using local variables aren't shared.
+// Shared subexpressions are returned in the order of evaluation, a subexpression may use preceding ones.
+func ShareSubexpressions(exps [][]Token) ([]Shared, [][]Token) {
+	trees := make([][]*node, 0, len(exps))
+	for _, exp := range exps {
+		trees = append(trees, buildTree(exp))
+	}
+
+	counts := map[string]int{}
+
+	for _, roots := range trees {
+		for _, root := range roots {
+			root.walk(func(n *node) bool {
+				if n.shareable() {
+					counts[n.key]++
+				}
+
+				return true
+			})
+		}
+	}
+
+	shared := map[string]bool{}
+
+	for key, count := range counts {
+		if count > 1 {
+			shared[key] = true
+		}
+	}
+
+	// A subexpression which is used only inside one shared subexpression isn't shared by itself
+	for removed := true; removed; {
+		removed = false
+
+		uses := map[string]int{}
+		defined := map[string]bool{}
+
+		var visit func(n *node) bool
+		visit = func(n *node) bool {
+			if !shared[n.key] || !n.shareable() {
+				return true
+			}
+
+			uses[n.key]++
+			if !defined[n.key] {
+				defined[n.key] = true
+
+				for _, child := range n.children {
+					child.walk(visit)
+				}
+			}
+
+			return false
+		}
+
+		for _, roots := range trees {
+			for _, root := range roots {
+				root.walk(visit)
+			}
+		}
+
+		for key := range shared {
+			if uses[key] < 2 {
+				delete(shared, key)
+
+				removed = true
+			}
+		}
+	}
+
+	s := &sharing{shared: shared, names: map[string]string{}}
+
+	res := make([][]Token, 0, len(trees))
+	for _, roots := range trees {
+		var exp []Token
+		for _, root := range roots {
+			exp = s.emit(exp, root, false)
+		}
+
+		res = append(res, exp)
+	}
+
+	return s.subexpressions, res
+}
+
+type sharing struct {
+	shared         map[string]bool
+	names          map[string]string
+	subexpressions []Shared
+}
+
+// emit appends the postfix subexpression to 'exp', shared subexpressions are replaced by SHARED tokens
+func (s *sharing) emit(exp []Token, n *node, definition bool) []Token {
+	if !definition && s.shared[n.key] && n.shareable() {
+		return append(exp, Token{Type: SHARED, Value: s.name(n), ValueType: UNKNOWN_TYPE, Pos: n.token.Pos})
+	}
+
+	for _, child := range n.children {
+		exp = s.emit(exp, child, false)
+	}
+
+	return append(exp, n.token)
+}
+
+// name returns the name of the shared subexpression, it is defined after subexpressions it uses
+func (s *sharing) name(n *node) string {
+	if name, ok := s.names[n.key]; ok {
+		return name
+	}
+
+	tokens := s.emit(nil, n, true)
+	name := "$" + strconv.Itoa(len(s.subexpressions)+1)
+
+	s.names[n.key] = name
+	s.subexpressions = append(s.subexpressions, Shared{Name: name, Tokens: tokens})
+
+	return name
+}
+
+// buildTree returns trees of local variables followed by the tree of the whole expression
+func buildTree(tokens []Token) []*node {
+	var (
+		stack  []*node
+		roots  []*node
+		locals = map[string]bool{}
+	)
+
+	pop := func() *node {
+		if len(stack) == 0 {
+			return &node{local: true}
+		}
+
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		return n
+	}
+
+	for _, token := range tokens {
+		n := &node{token: token}
+
+		switch token.Type {
+		case ASSIGN:
+			n.children = []*node{pop()}
+			n.local = true
+			locals[token.Value] = true
+			roots = append(roots, n)
+
+			continue
+		case LOG_OP, COMP_OP, ARITH_OP:
+			y := pop()
+			x := pop()
+			n.children = []*node{x, y}
+		case FUNC:
+			n.children = make([]*node, token.Args)
+			for i := len(n.children) - 1; i >= 0; i-- {
+				n.children[i] = pop()
+			}
+		case IDENT:
+			n.local = locals[token.Value]
+		}
+
+		keys := make([]string, 0, len(n.children))
+		for _, child := range n.children {
+			keys = append(keys, child.key)
+			n.local = n.local || child.local
+		}
+
+		n.key = string(token.Type) + ":" + strconv.Quote(token.Value) + ":" + string(token.ValueType)
+		if len(n.children) > 0 {
+			n.key += "(" + strings.Join(keys, ",") + ")"
+		}
+
+		stack = append(stack, n)
+	}
+
+	return append(roots, stack...)
+}
+
+func (n *node) shareable() bool {
+	return len(n.children) > 0 && !n.local
+}
+
+// walk calls 'f' for the node and its descendants, descendants are skipped if 'f' returns false
+func (n *node) walk(f func(n *node) bool) {
+	if !f(n) {
+		return
+	}
+
+	for _, child := range n.children {
+		child.walk(f)
+	}
+}
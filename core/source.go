@@ -0,0 +1,346 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ParamSource provides values of parameters by name or by dotted path like "company.founder.inn".
+// Lookup returns the value, whether the parameter is present and an error if the source failed.
+// Values are nil, bool, string, numbers (including json.Number), time.Time,
+// slices and maps, like after json.Unmarshal.
+type ParamSource interface {
+	Lookup(name string) (any, bool, error)
+}
+
+// ParamSourceFunc allows to use an ordinary function as a ParamSource
+type ParamSourceFunc func(name string) (any, bool, error)
+
+func (f ParamSourceFunc) Lookup(name string) (any, bool, error) {
+	return f(name)
+}
+
+// RawSource provides parameters from raw JSON values, e.g. from jparser.RawMessageSet.
+// A path is resolved through nested JSON objects if there is no parameter with the whole path as a name.
+func RawSource(params map[string]json.RawMessage) ParamSource {
+	return ParamSourceFunc(func(name string) (any, bool, error) {
+		if raw, ok := params[name]; ok {
+			return decodeRaw(raw)
+		}
+
+		head, rest, ok := strings.Cut(name, ".")
+		if !ok {
+			return nil, false, nil
+		}
+
+		raw, ok := params[head]
+		if !ok {
+			return nil, false, nil
+		}
+
+		value, _, err := decodeRaw(raw)
+		if err != nil {
+			return nil, false, err
+		}
+
+		value, ok = lookupPath(value, rest)
+
+		return value, ok, nil
+	})
+}
+
+func decodeRaw(raw json.RawMessage) (any, bool, error) {
+	if value, ok := decodeScalar(raw); ok {
+		return value, true, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, false, err
+	}
+
+	return value, true, nil
+}
+
+// decodeScalar decodes null, bools, numbers and strings without escapes like json.Decoder with UseNumber,
+// but without its buffers. Other values aren't decoded.
+func decodeScalar(raw json.RawMessage) (any, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || !json.Valid(raw) {
+		return nil, false
+	}
+
+	switch raw[0] {
+	case 'n':
+		return nil, true
+	case 't':
+		return true, true
+	case 'f':
+		return false, true
+	case '"':
+		if bytes.IndexByte(raw, '\\') >= 0 || !utf8.Valid(raw) {
+			return nil, false
+		}
+
+		return string(raw[1 : len(raw)-1]), true
+	case '{', '[':
+		return nil, false
+	default:
+		return json.Number(raw), true
+	}
+}
+
+// MapSource provides parameters from a map, a path is resolved through nested maps
+func MapSource(params map[string]any) ParamSource {
+	return ParamSourceFunc(func(name string) (any, bool, error) {
+		if value, ok := params[name]; ok {
+			return value, true, nil
+		}
+
+		value, ok := lookupPath(params, name)
+
+		return value, ok, nil
+	})
+}
+
+func lookupPath(value any, path string) (any, bool) {
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// StructSource provides fields of a struct (or a pointer to it) by names from `calc:"name"` tags.
+// Fields without the tag are available by their Go names, fields tagged `calc:"-"` are skipped.
+// A path is resolved through nested structs, nil pointers are treated as missing parameters.
+func StructSource(v any) ParamSource {
+	return ParamSourceFunc(func(name string) (any, bool, error) {
+		value := reflect.ValueOf(v)
+
+		for _, key := range strings.Split(name, ".") {
+			for value.Kind() == reflect.Pointer {
+				if value.IsNil() {
+					return nil, false, nil
+				}
+
+				value = value.Elem()
+			}
+
+			if value.Kind() != reflect.Struct {
+				return nil, false, nil
+			}
+
+			field, ok := StructFields(value.Type())[key]
+			if !ok {
+				return nil, false, nil
+			}
+
+			var err error
+			if value, err = value.FieldByIndexErr(field.Index); err != nil {
+				return nil, false, nil // nolint:nilerr
+			}
+		}
+
+		for value.Kind() == reflect.Pointer {
+			if value.IsNil() {
+				return nil, false, nil
+			}
+
+			value = value.Elem()
+		}
+
+		return value.Interface(), true, nil
+	})
+}
+
+// StructFields returns exported fields of the struct type by names from `calc` tags, see StructSource.
+// Fields of embedded structs without the tag are promoted like in encoding/json:
+// the shallower field wins, fields with the same name at the same depth are dropped.
+func StructFields(t reflect.Type) map[string]reflect.StructField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.(map[string]reflect.StructField) // nolint:forcetypeassert
+	}
+
+	fields := structFields{
+		res:       make(map[string]reflect.StructField, t.NumField()),
+		depths:    map[string]int{},
+		ambiguous: map[string]int{},
+		visiting:  map[reflect.Type]bool{},
+	}
+	fields.collect(t, nil)
+
+	for name, depth := range fields.ambiguous {
+		if fields.depths[name] == depth {
+			delete(fields.res, name)
+		}
+	}
+
+	structFieldsCache.Store(t, fields.res)
+
+	return fields.res
+}
+
+type structFields struct {
+	res       map[string]reflect.StructField
+	depths    map[string]int
+	ambiguous map[string]int
+	visiting  map[reflect.Type]bool
+}
+
+func (f *structFields) collect(t reflect.Type, index []int) {
+	if f.visiting[t] {
+		return
+	}
+
+	f.visiting[t] = true
+	defer delete(f.visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		field.Index = append(append([]int{}, index...), i)
+
+		name, tagged := field.Tag.Lookup("calc")
+		if name == "-" {
+			continue
+		}
+
+		if field.Anonymous && !tagged {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				f.collect(embedded, field.Index)
+
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if !tagged {
+			name = field.Name
+		}
+
+		depth := len(index)
+		if known, ok := f.depths[name]; ok && known <= depth {
+			if known == depth {
+				f.ambiguous[name] = depth
+			}
+
+			continue
+		}
+
+		f.res[name] = field
+		f.depths[name] = depth
+	}
+}
+
+// nolint:gochecknoglobals
+var structFieldsCache sync.Map
+
+// LazySource calls 'fetch' on the first lookup of each parameter and keeps the result.
+// It is safe for concurrent use.
+func LazySource(fetch func(name string) (any, bool, error)) ParamSource {
+	type fetched struct {
+		value any
+		ok    bool
+	}
+
+	var (
+		mu    sync.Mutex
+		cache = map[string]fetched{}
+	)
+
+	return ParamSourceFunc(func(name string) (any, bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if res, ok := cache[name]; ok {
+			return res.value, res.ok, nil
+		}
+
+		value, ok, err := fetch(name)
+		if err != nil {
+			return nil, false, err
+		}
+
+		cache[name] = fetched{value: value, ok: ok}
+
+		return value, ok, nil
+	})
+}
+
+// strictParam keeps the string value of a parameter declared as a number or a bool quoted like in JSON,
+// so operators fail with a typecast error unless types are lenient, e.g. "1500" isn't the number 1500
+func strictParam(declared ValueType, value any, formatted string, lenient bool) string {
+	if _, ok := value.(string); ok && !lenient && (declared == NUMBER_TYPE || declared == BOOL_TYPE) {
+		return strconv.Quote(formatted)
+	}
+
+	return formatted
+}
+
+// formatParam converts the value of the parameter to the string value of a token
+func formatParam(name string, value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case json.RawMessage:
+		return string(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	}
+
+	rv := reflect.ValueOf(value)
+
+	switch rv.Kind() { // nolint:exhaustive
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", &CalculationError{
+			Reason: errTypeCast,
+			Value:  fmt.Sprintf("parameter '%s' of type %T", name, value),
+		}
+	}
+
+	return string(raw), nil
+}
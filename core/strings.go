@@ -0,0 +1,87 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const errNotInteger = "number is not an integer"
+
+// lenFunc returns the number of characters of the string
+func lenFunc(_ *env, args []Token) (*Token, error) {
+	return numberToken(float64(utf8.RuneCountInString(args[0].Value))), nil
+}
+
+func upperFunc(_ *env, args []Token) (*Token, error) {
+	return stringToken(strings.ToUpper(args[0].Value)), nil
+}
+
+func lowerFunc(_ *env, args []Token) (*Token, error) {
+	return stringToken(strings.ToLower(args[0].Value)), nil
+}
+
+// trimFunc removes leading and trailing white space
+func trimFunc(_ *env, args []Token) (*Token, error) {
+	return stringToken(strings.TrimSpace(args[0].Value)), nil
+}
+
+func containsFunc(_ *env, args []Token) (*Token, error) {
+	return boolToken(strings.Contains(args[0].Value, args[1].Value)), nil
+}
+
+func startsWithFunc(_ *env, args []Token) (*Token, error) {
+	return boolToken(strings.HasPrefix(args[0].Value, args[1].Value)), nil
+}
+
+// substringFunc returns 'length' characters starting from the character 'start' (from 0),
+// by default up to the end of the string. The range is truncated to the bounds of the string.
+func substringFunc(_ *env, args []Token) (*Token, error) {
+	chars := []rune(args[0].Value)
+
+	start, err := parseInt(args[1].Value)
+	if err != nil {
+		return nil, err
+	}
+
+	end := len(chars)
+
+	if len(args) > 2 {
+		length, err := parseInt(args[2].Value)
+		if err != nil {
+			return nil, err
+		}
+
+		if start+length < end {
+			end = start + length
+		}
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if start >= end {
+		return stringToken(""), nil
+	}
+
+	return stringToken(string(chars[start:end])), nil
+}
+
+func parseInt(value string) (int, error) {
+	number, ok := parseNumber(value)
+	if !ok {
+		return 0, typeCastError(value, NUMBER_TYPE)
+	}
+
+	if number != math.Trunc(number) || math.Abs(number) > math.MaxInt32 {
+		return 0, &CalculationError{Reason: errNotInteger, Value: fmt.Sprintf("'%s'", value)}
+	}
+
+	return int(number), nil
+}
+
+func stringToken(value string) *Token {
+	return &Token{Type: STRING, Value: value, ValueType: STRING_TYPE}
+}
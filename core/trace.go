@@ -0,0 +1,209 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Trace is a node of the evaluation tree: an operator with its operands or a single operand
+type Trace struct {
+	Expr     string   `json:"expr"`
+	Value    string   `json:"value"`
+	Children []*Trace `json:"children,omitempty"`
+}
+
+// String renders the node and its operators one per line, e.g.
+//
+//	(s2001 (1500000) > stated_capital (10000)) AND (s2001 (1500000) > 1000000) -> true
+//	  s2001 (1500000) > stated_capital (10000) -> true
+//	  s2001 (1500000) > 1000000 -> true
+func (t *Trace) String() string {
+	var sb strings.Builder
+
+	t.render(&sb, 0)
+
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (t *Trace) render(sb *strings.Builder, depth int) {
+	fmt.Fprintf(sb, "%s%s -> %s\n", strings.Repeat("  ", depth), t.Expr, t.Value)
+
+	for _, child := range t.Children {
+		if len(child.Children) > 0 {
+			child.render(sb, depth+1)
+		}
+	}
+}
+
+// tracer records Trace nodes in parallel with the evaluation stack.
+// All methods do nothing on a nil tracer.
+type tracer struct {
+	stack []*Trace
+	roots []*Trace
+}
+
+// literal records a number, a bool or a string literal
+func (t *tracer) literal(token Token) {
+	if t == nil {
+		return
+	}
+
+	expr := token.Value
+	if token.Type == STRING {
+		expr = strconv.Quote(token.Value)
+	}
+
+	t.stack = append(t.stack, &Trace{Expr: expr, Value: displayValue(token.Value)})
+}
+
+// namedOperand records a value taken by name, like a parameter or a local variable
+func (t *tracer) namedOperand(name, value string) {
+	if t == nil {
+		return
+	}
+
+	display := displayValue(value)
+	t.stack = append(t.stack, &Trace{Expr: fmt.Sprintf("%s (%s)", name, display), Value: display})
+}
+
+func (t *tracer) operator(operator string, res Token) {
+	if t == nil {
+		return
+	}
+
+	y, x := t.pop(), t.pop()
+
+	t.stack = append(t.stack, &Trace{
+		Expr:     fmt.Sprintf("%s %s %s", x.operandExpr(), operator, y.operandExpr()),
+		Value:    displayValue(displayNull(res)),
+		Children: []*Trace{x, y},
+	})
+}
+
+// call records a function call with its arguments
+func (t *tracer) call(name string, args int, res Token) {
+	if t == nil {
+		return
+	}
+
+	children := make([]*Trace, args)
+	exprs := make([]string, args)
+
+	for i := args - 1; i >= 0; i-- {
+		children[i] = t.pop()
+		exprs[i] = children[i].Expr
+	}
+
+	t.stack = append(t.stack, &Trace{
+		Expr:     fmt.Sprintf("%s(%s)", name, strings.Join(exprs, ", ")),
+		Value:    displayValue(displayNull(res)),
+		Children: children,
+	})
+}
+
+func (t *tracer) assign(name string) {
+	if t == nil {
+		return
+	}
+
+	value := t.pop()
+
+	t.roots = append(t.roots, &Trace{
+		Expr:     fmt.Sprintf("let %s = %s", name, value.Expr),
+		Value:    value.Value,
+		Children: value.Children,
+	})
+}
+
+// result returns traces of local variables followed by the trace of the whole expression
+func (t *tracer) result() []*Trace {
+	if t == nil {
+		return nil
+	}
+
+	return append(t.roots, t.pop())
+}
+
+func (t *tracer) pop() *Trace {
+	if len(t.stack) == 0 {
+		return &Trace{}
+	}
+
+	node := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+
+	return node
+}
+
+func (t *Trace) operandExpr() string {
+	if len(t.Children) > 0 {
+		return "(" + t.Expr + ")"
+	}
+
+	return t.Expr
+}
+
+// FormatPostfix renders the postfix expression in the infix notation, e.g. for debugging:
+//
+//	let x = s2001 * 2 in (x > $1) AND exists(s6004)
+func FormatPostfix(tokens []Token) string {
+	var (
+		stack    []*Trace
+		bindings []string
+	)
+
+	pop := func() *Trace {
+		if len(stack) == 0 {
+			return &Trace{}
+		}
+
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		return node
+	}
+
+	for _, token := range tokens {
+		switch token.Type {
+		case LOG_OP, COMP_OP, ARITH_OP:
+			y, x := pop(), pop()
+			stack = append(stack, &Trace{
+				Expr:     fmt.Sprintf("%s %s %s", x.operandExpr(), token.Value, y.operandExpr()),
+				Children: []*Trace{x, y},
+			})
+		case FUNC:
+			exprs := make([]string, token.Args)
+			for i := token.Args - 1; i >= 0; i-- {
+				exprs[i] = pop().Expr
+			}
+
+			stack = append(stack, &Trace{Expr: fmt.Sprintf("%s(%s)", token.Value, strings.Join(exprs, ", "))})
+		case ASSIGN:
+			bindings = append(bindings, fmt.Sprintf("%s = %s", token.Value, pop().Expr))
+		case STRING:
+			stack = append(stack, &Trace{Expr: strconv.Quote(token.Value)})
+		case FORMULA_REF:
+			stack = append(stack, &Trace{Expr: "@" + token.Value})
+		default:
+			stack = append(stack, &Trace{Expr: displayValue(token.Value)})
+		}
+	}
+
+	res := pop().Expr
+	if len(bindings) > 0 {
+		res = fmt.Sprintf("let %s in %s", strings.Join(bindings, ", "), res)
+	}
+
+	return res
+}
+
+// displayValue trims trailing zeros of calculated numbers
+func displayValue(value string) string {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || !strings.Contains(value, ".") {
+		return value
+	}
+
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
@@ -0,0 +1,70 @@
+package core
+
+// CheckTypes checks arguments of functions in the postfix expression before the evaluation
+// and returns the type of the result. Parameters and results of UNKNOWN_TYPE are checked during the evaluation.
+func CheckTypes(tokens []Token) (ValueType, error) {
+	var (
+		stack  []ValueType
+		locals = map[string]ValueType{}
+	)
+
+	pop := func() ValueType {
+		if len(stack) == 0 {
+			return UNKNOWN_TYPE
+		}
+
+		valueType := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		return valueType
+	}
+
+	for _, token := range tokens {
+		switch token.Type {
+		case NUMBER, BOOL, STRING, DATE, NULL, FORMULA_REF:
+			stack = append(stack, token.ValueType)
+		case IDENT:
+			valueType, ok := locals[token.Value]
+			if !ok {
+				valueType = token.ValueType
+			}
+
+			if valueType == "" {
+				valueType = UNKNOWN_TYPE
+			}
+
+			stack = append(stack, valueType)
+		case ASSIGN:
+			locals[token.Value] = pop()
+		case LOG_OP, COMP_OP:
+			pop()
+			pop()
+			stack = append(stack, BOOL_TYPE)
+		case ARITH_OP:
+			pop()
+			pop()
+			stack = append(stack, NUMBER_TYPE)
+		case FUNC:
+			fn, ok := functions[token.Value]
+			if !ok {
+				return "", &CalculationError{Reason: errUnknownToken, Value: token.Value}
+			}
+
+			argTypes := make([]ValueType, token.Args)
+			for i := len(argTypes) - 1; i >= 0; i-- {
+				argTypes[i] = pop()
+			}
+
+			resultType, err := fn.checkArgs(token.Value, argTypes)
+			if err != nil {
+				return "", err
+			}
+
+			stack = append(stack, resultType)
+		default:
+			return "", &UnknownTokenTypeError{TokenType: token.Type}
+		}
+	}
+
+	return pop(), nil
+}
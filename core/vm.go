@@ -0,0 +1,395 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// RefResolver returns the result of the referenced formula for the FORMULA_REF token as a literal token
+// and the value of the shared subexpression for the SHARED token
+type RefResolver func(ref Token) (Token, error)
+
+// value is a value of the VM stack. Numbers are kept parsed,
+// their string form is formatted only if it is needed, e.g. for functions.
+type value struct {
+	typ ValueType
+	// tokenType is IDENT for missing parameters and NULL for null values, see nullError
+	tokenType TokenType
+	str       string
+	num       float64
+	hasStr    bool
+	hasNum    bool
+	// rounded numbers are results of arithmetic operators, the interpreter formats them with "%f"
+	rounded bool
+}
+
+func tokenValue(token Token) value {
+	return value{typ: token.ValueType, tokenType: token.Type, str: token.Value, hasStr: true}
+}
+
+func numberValue(number float64) value {
+	return value{typ: NUMBER_TYPE, tokenType: NUMBER, num: roundNumber(number), hasNum: true, rounded: true}
+}
+
+func boolValue(b bool) value {
+	return value{typ: BOOL_TYPE, tokenType: BOOL, str: strconv.FormatBool(b), hasStr: true}
+}
+
+func (v value) string() string {
+	switch {
+	case v.hasStr || !v.hasNum:
+		return v.str
+	case v.rounded:
+		return strconv.FormatFloat(v.num, 'f', 6, 64)
+	default:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	}
+}
+
+func (v value) number() (float64, bool) {
+	if v.hasNum {
+		return v.num, true
+	}
+
+	number, err := strconv.ParseFloat(v.str, 64)
+
+	return number, err == nil
+}
+
+func (v value) token() Token {
+	return Token{Type: v.tokenType, Value: v.string(), ValueType: v.typ}
+}
+
+// roundNumber rounds the result of an arithmetic operator to 6 decimal places like the interpreter
+func roundNumber(number float64) float64 {
+	var buf [64]byte
+
+	rounded, err := strconv.ParseFloat(string(strconv.AppendFloat(buf[:0], number, 'f', 6, 64)), 64)
+	if err != nil {
+		return number
+	}
+
+	return rounded
+}
+
+// vm keeps buffers between evaluations, so an evaluation usually doesn't allocate memory
+type vm struct {
+	stack  []value
+	params []value
+	loaded []bool
+	locals []value
+	args   []Token
+	env    env
+}
+
+// nolint:gochecknoglobals
+var vmPool = sync.Pool{New: func() any { return &vm{} }}
+
+// Evaluate evaluates the bytecode like EvaluateWithOptions, but doesn't record traces.
+// Each parameter is looked up once, results of referenced formulas are taken from 'refs'.
+func (b *Bytecode) Evaluate(ctx context.Context, source ParamSource, refs RefResolver, opts Options) (Token, error) {
+	m := vmPool.Get().(*vm) // nolint:forcetypeassert
+	defer vmPool.Put(m)
+
+	res, err := m.run(ctx, b, source, refs, opts)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return res.result()
+}
+
+// EvaluateSubexpression evaluates the bytecode like Evaluate, but returns the value as it is used
+// by an enclosing expression, e.g. a null value isn't an error
+func (b *Bytecode) EvaluateSubexpression(ctx context.Context, source ParamSource, refs RefResolver, opts Options,
+) (Token, error) {
+	m := vmPool.Get().(*vm) // nolint:forcetypeassert
+	defer vmPool.Put(m)
+
+	res, err := m.run(ctx, b, source, refs, opts)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return res.token(), nil
+}
+
+func (m *vm) reset(b *Bytecode, opts Options) {
+	m.env.init(opts)
+
+	if cap(m.stack) < b.stack {
+		m.stack = make([]value, 0, b.stack)
+	}
+
+	if cap(m.params) < len(b.params) {
+		m.params = make([]value, len(b.params))
+		m.loaded = make([]bool, len(b.params))
+	}
+
+	if cap(m.locals) < b.locals {
+		m.locals = make([]value, b.locals)
+	}
+
+	m.stack = m.stack[:0]
+	m.params = m.params[:len(b.params)]
+	m.loaded = m.loaded[:len(b.params)]
+	m.locals = m.locals[:b.locals]
+
+	for i := range m.loaded {
+		m.loaded[i] = false
+	}
+}
+
+func (m *vm) run(ctx context.Context, b *Bytecode, source ParamSource, refs RefResolver, opts Options,
+) (value, error) {
+	m.reset(b, opts)
+
+	for i, ins := range b.code {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return value{}, err
+			}
+		}
+
+		switch ins.op {
+		case opConst:
+			m.push(b.consts[ins.arg])
+		case opParam:
+			if !m.loaded[ins.arg] {
+				param, err := loadParam(source, b.params[ins.arg], opts.Lenient)
+				if err != nil {
+					return value{}, err
+				}
+
+				m.params[ins.arg], m.loaded[ins.arg] = param, true
+			}
+
+			m.push(m.params[ins.arg])
+		case opRef:
+			if refs == nil {
+				return value{}, &CalculationError{Reason: errUnknownToken, Value: b.refs[ins.arg].Value}
+			}
+
+			ref, err := refs(b.refs[ins.arg])
+			if err != nil {
+				return value{}, err
+			}
+
+			m.push(tokenValue(ref))
+		case opLocal:
+			m.push(m.locals[ins.arg])
+		case opStore:
+			m.locals[ins.arg] = m.pop()
+		case opCall:
+			res, err := m.call(b.funcs[ins.arg], ins.args)
+			if err != nil {
+				return value{}, err
+			}
+
+			m.push(res)
+		default:
+			y := m.pop()
+			x := m.pop()
+
+			res, err := m.operator(ins.op, x, y, opts.Lenient)
+			if err != nil {
+				return value{}, err
+			}
+
+			m.push(res)
+		}
+	}
+
+	return m.pop(), nil
+}
+
+func (m *vm) push(v value) {
+	m.stack = append(m.stack, v)
+}
+
+func (m *vm) pop() value {
+	if len(m.stack) == 0 {
+		return value{}
+	}
+
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+
+	return v
+}
+
+func (m *vm) call(name string, args int) (value, error) {
+	if cap(m.args) < args {
+		m.args = make([]Token, args)
+	}
+
+	m.args = m.args[:args]
+	for i := args - 1; i >= 0; i-- {
+		m.args[i] = m.pop().token()
+	}
+
+	res, err := callFunction(&m.env, name, m.args)
+	if err != nil {
+		return value{}, err
+	}
+
+	return tokenValue(*res), nil
+}
+
+// operator applies the operator to numbers, bools and strings directly,
+// other operands and failed conversions are handled by functions of the interpreter
+func (m *vm) operator(op opcode, x, y value, lenient bool) (value, error) {
+	// Nulls propagate up to the result or to a null function like exists
+	if x.typ == NULL_TYPE {
+		return x, nil
+	}
+
+	if y.typ == NULL_TYPE {
+		return y, nil
+	}
+
+	if x.typ == y.typ {
+		if res, ok := applyOperator(op, x, y); ok {
+			return res, nil
+		}
+	}
+
+	xToken, yToken := x.token(), y.token()
+	if lenient {
+		xToken, yToken = coerceOperands(&m.env, xToken, yToken)
+	}
+
+	res, err := operatorFuncs[operatorNames[op]](&m.env, xToken, yToken)
+	if err != nil {
+		return value{}, err
+	}
+
+	return tokenValue(*res), nil
+}
+
+// nolint:cyclop,exhaustive
+func applyOperator(op opcode, x, y value) (value, bool) {
+	switch x.typ {
+	case NUMBER_TYPE:
+		a, ok := x.number()
+		if !ok {
+			return value{}, false
+		}
+
+		b, ok := y.number()
+		if !ok {
+			return value{}, false
+		}
+
+		switch op {
+		case opAdd:
+			return numberValue(a + b), true
+		case opSub:
+			return numberValue(a - b), true
+		case opMul:
+			return numberValue(a * b), true
+		case opDiv:
+			if b == 0 {
+				return value{}, false
+			}
+
+			return numberValue(a / b), true
+		case opEqual:
+			return boolValue(a == b), true
+		case opNotEqual:
+			return boolValue(a != b), true
+		case opMore:
+			return boolValue(a > b), true
+		case opLess:
+			return boolValue(a < b), true
+		case opMoreEqual:
+			return boolValue(a >= b), true
+		case opLessEqual:
+			return boolValue(a <= b), true
+		}
+	case BOOL_TYPE:
+		a, err := strconv.ParseBool(x.string())
+		if err != nil {
+			return value{}, false
+		}
+
+		b, err := strconv.ParseBool(y.string())
+		if err != nil {
+			return value{}, false
+		}
+
+		switch op {
+		case opAnd:
+			return boolValue(a && b), true
+		case opOr:
+			return boolValue(a || b), true
+		case opEqual:
+			return boolValue(a == b), true
+		case opNotEqual:
+			return boolValue(a != b), true
+		}
+	case STRING_TYPE:
+		switch op {
+		case opEqual:
+			return boolValue(x.string() == y.string()), true
+		case opNotEqual:
+			return boolValue(x.string() != y.string()), true
+		}
+	}
+
+	return value{}, false
+}
+
+// loadParam looks up the parameter like the interpreter does for IDENT tokens
+func loadParam(source ParamSource, p param, lenient bool) (value, error) {
+	param, ok, err := source.Lookup(p.name)
+	if err != nil {
+		return value{}, &ParamSourceError{Param: p.name, Err: err}
+	}
+
+	// A missing parameter is null, it fails the evaluation if it isn't handled by null functions
+	if !ok || param == nil {
+		res := value{typ: NULL_TYPE, tokenType: NULL, str: p.name, hasStr: true}
+		if !ok {
+			res.tokenType = IDENT
+		}
+
+		return res, nil
+	}
+
+	res := value{typ: paramValueType(p.valueType, param), tokenType: IDENT}
+
+	if number, ok := param.(float64); ok {
+		res.num, res.hasNum = number, true
+
+		return res, nil
+	}
+
+	str, err := formatParam(p.name, param)
+	if err != nil {
+		return value{}, err
+	}
+
+	res.str, res.hasStr = strictParam(p.valueType, param, str, lenient), true
+
+	return res, nil
+}
+
+// result converts the value on the top of the stack to the result like the interpreter
+func (v value) result() (Token, error) {
+	switch v.typ {
+	case NULL_TYPE:
+		return Token{}, nullError(v.token())
+	case BOOL_TYPE:
+		return Token{Type: BOOL, Value: v.string(), ValueType: BOOL_TYPE}, nil
+	case NUMBER_TYPE:
+		return Token{Type: NUMBER, Value: v.string(), ValueType: NUMBER_TYPE}, nil
+	case STRING_TYPE:
+		return Token{Type: STRING, Value: v.string(), ValueType: STRING_TYPE}, nil
+	case DATE_TYPE:
+		return Token{Type: DATE, Value: v.string(), ValueType: DATE_TYPE}, nil
+	default:
+		return Token{}, &CalculationError{Reason: errUnknownToken, Value: v.string()}
+	}
+}
@@ -0,0 +1,159 @@
+package calculator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/egelis/calculator/core"
+)
+
+type UnknownFormulaError struct {
+	Formula string
+	Ref     string
+}
+
+func (e *UnknownFormulaError) Error() string {
+	return fmt.Sprintf("formula '%s' references unknown or disabled formula: %s", e.Formula, e.Ref)
+}
+
+type FormulaCycleError struct {
+	Cycle []string
+}
+
+func (e *FormulaCycleError) Error() string {
+	return fmt.Sprintf("formula dependency cycle: %s", strings.Join(e.Cycle, " -> "))
+}
+
+const (
+	notVisited = iota
+	inProgress
+	visited
+)
+
+// orderFormulas sorts formulas so that each formula goes after all formulas it references.
+// The original order is kept for independent formulas.
+func orderFormulas(formulas []tokenizedFormula) ([]tokenizedFormula, error) {
+	byName := make(map[string]int, len(formulas))
+	for i, formula := range formulas {
+		byName[formula.Name] = i
+	}
+
+	var (
+		res   = make([]tokenizedFormula, 0, len(formulas))
+		state = make([]int, len(formulas))
+		path  []string
+		visit func(i int) error
+	)
+
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case inProgress:
+			return &FormulaCycleError{Cycle: cycleFrom(path, formulas[i].Name)}
+		}
+
+		state[i] = inProgress
+		path = append(path, formulas[i].Name)
+
+		for _, ref := range formulas[i].Refs {
+			j, ok := byName[ref]
+			if !ok {
+				return &UnknownFormulaError{Formula: formulas[i].Name, Ref: ref}
+			}
+
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[i] = visited
+		res = append(res, formulas[i])
+
+		return nil
+	}
+
+	for i := range formulas {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func cycleFrom(path []string, name string) []string {
+	for i := range path {
+		if path[i] == name {
+			cycle := make([]string, 0, len(path)-i+1)
+			cycle = append(cycle, path[i:]...)
+
+			return append(cycle, name)
+		}
+	}
+
+	return []string{name, name}
+}
+
+// resolveRef returns the result of the referenced formula as a literal token, it is a core.RefResolver
+func (r FormulaResult) resolveRef(ref core.Token) (core.Token, error) {
+	value, ok := r[ref.Value]
+	if !ok {
+		return core.Token{}, &UnknownFormulaError{Ref: ref.Value}
+	}
+
+	if ref.ValueType != core.NUMBER_TYPE {
+		return core.Token{Type: core.BOOL, Value: strconv.FormatBool(value.Result), ValueType: core.BOOL_TYPE}, nil
+	}
+
+	// A score without value is null, just like a missing parameter
+	if value.Number == nil {
+		return core.Token{Type: core.NULL, Value: "@" + ref.Value, ValueType: core.NULL_TYPE}, nil
+	}
+
+	return core.Token{
+		Type:      core.NUMBER,
+		Value:     strconv.FormatFloat(*value.Number, 'f', -1, 64),
+		ValueType: core.NUMBER_TYPE,
+	}, nil
+}
+
+// setFormulaRefTypes sets the result type of the referenced formula to each reference
+func setFormulaRefTypes(formulas []tokenizedFormula) {
+	resultTypes := make(map[string]core.ValueType, len(formulas))
+	for _, formula := range formulas {
+		resultTypes[formula.Name] = formula.ResultType
+	}
+
+	for _, formula := range formulas {
+		for i, token := range formula.Tokens {
+			if resultType, ok := resultTypes[token.Value]; ok && token.Type == core.FORMULA_REF {
+				formula.Tokens[i].ValueType = resultType
+			}
+		}
+	}
+}
+
+func formulaRefs(tokens []core.Token) []string {
+	var (
+		refs []string
+		seen = map[string]struct{}{}
+	)
+
+	for _, token := range tokens {
+		if token.Type != core.FORMULA_REF {
+			continue
+		}
+
+		if _, ok := seen[token.Value]; ok {
+			continue
+		}
+
+		seen[token.Value] = struct{}{}
+		refs = append(refs, token.Value)
+	}
+
+	return refs
+}
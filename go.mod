@@ -2,4 +2,4 @@ module github.com/egelis/calculator
 
 go 1.19
 
-require github.com/egelis/jparser v0.0.0-20221230132355-27ad9cbbb35b // indirect
+require github.com/egelis/jparser v0.0.0-20221230132355-27ad9cbbb35b
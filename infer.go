@@ -0,0 +1,105 @@
+package calculator
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/egelis/calculator/core"
+	"github.com/egelis/jparser"
+)
+
+// TypeConflict is a parameter which has different types in samples
+type TypeConflict struct {
+	Param string
+	// Types are in order of appearance in samples
+	Types []core.ValueType
+}
+
+// InferParamTypes proposes types of parameters from sample sets:
+// JSON numbers, booleans, strings, arrays and strings with ISO dates (see core.ParseDate).
+// Values which are null or objects don't define a type.
+// A parameter with both dates and other strings is a string.
+// Parameters with other different types are reported as conflicts and left out of the result.
+func InferParamTypes(samples []jparser.RawMessageSet) (map[string]core.ValueType, []TypeConflict) {
+	seen := map[string][]core.ValueType{}
+
+	for _, sample := range samples {
+		for param, raw := range sample {
+			valueType := inferType(raw)
+			if valueType == core.UNKNOWN_TYPE {
+				continue
+			}
+
+			if !containsType(seen[param], valueType) {
+				seen[param] = append(seen[param], valueType)
+			}
+		}
+	}
+
+	var (
+		res       = make(map[string]core.ValueType, len(seen))
+		conflicts []TypeConflict
+	)
+
+	for param, valueTypes := range seen {
+		if len(valueTypes) == 2 && containsType(valueTypes, core.STRING_TYPE) && containsType(valueTypes, core.DATE_TYPE) {
+			res[param] = core.STRING_TYPE
+
+			continue
+		}
+
+		if len(valueTypes) > 1 {
+			conflicts = append(conflicts, TypeConflict{Param: param, Types: valueTypes})
+
+			continue
+		}
+
+		res[param] = valueTypes[0]
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Param < conflicts[j].Param
+	})
+
+	return res, conflicts
+}
+
+func inferType(raw json.RawMessage) core.ValueType {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return core.UNKNOWN_TYPE
+	}
+
+	switch raw[0] {
+	case '"':
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return core.UNKNOWN_TYPE
+		}
+
+		if _, ok := core.ParseDate(value); ok {
+			return core.DATE_TYPE
+		}
+
+		return core.STRING_TYPE
+	case '[':
+		return core.ARRAY_TYPE
+	case 't', 'f':
+		return core.BOOL_TYPE
+	case 'n', '{':
+		return core.UNKNOWN_TYPE
+	default:
+		return core.NUMBER_TYPE
+	}
+}
+
+func containsType(valueTypes []core.ValueType, valueType core.ValueType) bool {
+	for _, v := range valueTypes {
+		if v == valueType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,60 @@
+// nolint:revive
+package calculator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/egelis/calculator/core"
+	"github.com/egelis/jparser"
+)
+
+func TestInferParamTypes(t *testing.T) {
+	t.Parallel()
+
+	samples := []jparser.RawMessageSet{
+		{
+			"s2001":      json.RawMessage(`2000000`),
+			"bool_param": json.RawMessage(`false`),
+			"name":       json.RawMessage(`"Romashka"`),
+			"reg_date":   json.RawMessage(`"2008-10-03"`),
+			"updated_at": json.RawMessage(`"2021-09-09T10:00:00Z"`),
+			"branches":   json.RawMessage(`[1, 2]`),
+			"inn":        json.RawMessage(`"6663003127"`),
+			"okved":      json.RawMessage(`null`),
+		},
+		{
+			"s2001":    json.RawMessage(`-10.5`),
+			"name":     json.RawMessage(`"2011-09-02"`),
+			"inn":      json.RawMessage(`6663003127`),
+			"okved":    json.RawMessage(`"64.19"`),
+			"reg_date": json.RawMessage(`null`),
+		},
+		{
+			"inn": json.RawMessage(`true`),
+		},
+	}
+
+	res, conflicts := InferParamTypes(samples)
+
+	expected := map[string]core.ValueType{
+		"s2001":      core.NUMBER_TYPE,
+		"bool_param": core.BOOL_TYPE,
+		"name":       core.STRING_TYPE,
+		"reg_date":   core.DATE_TYPE,
+		"updated_at": core.DATE_TYPE,
+		"branches":   core.ARRAY_TYPE,
+		"okved":      core.STRING_TYPE,
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("InferParamTypes() got = %v, expected = %v", res, expected)
+	}
+
+	expectedConflicts := []TypeConflict{
+		{Param: "inn", Types: []core.ValueType{core.STRING_TYPE, core.NUMBER_TYPE, core.BOOL_TYPE}},
+	}
+	if !reflect.DeepEqual(conflicts, expectedConflicts) {
+		t.Errorf("InferParamTypes() got conflicts = %v, expected = %v", conflicts, expectedConflicts)
+	}
+}
@@ -0,0 +1,128 @@
+package calculator
+
+import (
+	"fmt"
+
+	"github.com/egelis/calculator/core"
+)
+
+type Limit string
+
+const (
+	ExpressionLengthLimit Limit = "expression length"
+	TokensLimit           Limit = "number of tokens"
+	DepthLimit            Limit = "nesting depth"
+	FunctionCallsLimit    Limit = "number of function calls"
+	StepsLimit            Limit = "evaluation steps"
+)
+
+// DefaultMaxDepth is the nesting depth of brackets allowed if Limits.MaxDepth is zero
+const DefaultMaxDepth = 100
+
+// Limits protect against hostile or runaway formulas, zero values mean no limit except MaxDepth.
+// Limits are checked by Compile, so they don't slow down the calculation. Load doesn't parse formulas,
+// so it checks only MaxFunctionCalls and MaxSteps, other limits apply to the source of formulas.
+type Limits struct {
+	// MaxExpressionLength is the maximum number of characters of Formula.Expression
+	MaxExpressionLength int
+	// MaxTokens is the maximum number of tokens in a formula
+	MaxTokens int
+	// MaxDepth is the maximum nesting of brackets, DefaultMaxDepth if zero and no limit if negative
+	MaxDepth int
+	// MaxFunctionCalls is the maximum number of function calls in a formula
+	MaxFunctionCalls int
+	// MaxSteps is the maximum number of evaluation steps of a formula for one set of parameters:
+	// each operand, operator, function call and local variable of the parsed formula is one step
+	MaxSteps int
+}
+
+type LimitError struct {
+	Formula string
+	Limit   Limit
+	Max     int
+	Actual  int
+}
+
+func (e *LimitError) Error() string {
+	if e.Actual == 0 {
+		return fmt.Sprintf("formula '%s': %s exceeds the limit %d", e.Formula, e.Limit, e.Max)
+	}
+
+	return fmt.Sprintf("formula '%s': %s %d exceeds the limit %d", e.Formula, e.Limit, e.Actual, e.Max)
+}
+
+// checkExpression checks limits which can be checked before tokenizing
+func (l Limits) checkExpression(formula Formula) error {
+	if length := len([]rune(formula.Expression)); exceeds(length, l.MaxExpressionLength) {
+		return &LimitError{Formula: formula.Name, Limit: ExpressionLengthLimit, Max: l.MaxExpressionLength, Actual: length}
+	}
+
+	return nil
+}
+
+// checkTokens checks limits of the tokenized formula, it must be done before parsing
+func (l Limits) checkTokens(name string, tokens []core.Token) error {
+	if exceeds(len(tokens), l.MaxTokens) {
+		return &LimitError{Formula: name, Limit: TokensLimit, Max: l.MaxTokens, Actual: len(tokens)}
+	}
+
+	var depth, maxDepth, calls int
+
+	for _, token := range tokens {
+		switch token.Type {
+		case core.LBR:
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case core.RBR:
+			depth--
+		case core.FUNC:
+			calls++
+		}
+	}
+
+	if maxDepthLimit := l.maxDepth(); exceeds(maxDepth, maxDepthLimit) {
+		return &LimitError{Formula: name, Limit: DepthLimit, Max: maxDepthLimit, Actual: maxDepth}
+	}
+
+	if exceeds(calls, l.MaxFunctionCalls) {
+		return &LimitError{Formula: name, Limit: FunctionCallsLimit, Max: l.MaxFunctionCalls, Actual: calls}
+	}
+
+	return nil
+}
+
+// checkPostfix checks limits of the parsed formula, the evaluation makes exactly one step per token.
+// Steps are counted before optimizations, so they don't depend on them or on the way the formula is evaluated.
+func (l Limits) checkPostfix(name string, postfix []core.Token) error {
+	if exceeds(len(postfix), l.MaxSteps) {
+		return &LimitError{Formula: name, Limit: StepsLimit, Max: l.MaxSteps, Actual: len(postfix)}
+	}
+
+	var calls int
+
+	for _, token := range postfix {
+		if token.Type == core.FUNC {
+			calls++
+		}
+	}
+
+	if exceeds(calls, l.MaxFunctionCalls) {
+		return &LimitError{Formula: name, Limit: FunctionCallsLimit, Max: l.MaxFunctionCalls, Actual: calls}
+	}
+
+	return nil
+}
+
+func (l Limits) maxDepth() int {
+	if l.MaxDepth == 0 {
+		return DefaultMaxDepth
+	}
+
+	return l.MaxDepth
+}
+
+func exceeds(value, limit int) bool {
+	return limit > 0 && value > limit
+}
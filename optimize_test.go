@@ -0,0 +1,90 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/egelis/calculator/core"
+)
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: "1=2 AND 1=1 OR 1=1", expected: "true"},
+		{expression: "s2001 * (2 + 3)", expected: "s2001 5.000000 *"},
+		{expression: "true AND s2001 > 0", expected: "s2001 0 >"},
+		{expression: "(s2001 > 0 OR false) AND (true)", expected: "s2001 0 >"},
+		{expression: "true AND bool_param", expected: "true bool_param AND"},
+		{expression: "false AND s2001 > 0", expected: "false"},
+		{expression: "1=2 AND s2001 > 0", expected: "false"},
+		{expression: "s2001 > 0 AND false", expected: "false"},
+		{expression: "(false AND s2001 > 0) = false", expected: "false s2001 0 > AND false ="},
+		{expression: "true OR exists(s2001)", expected: "true"},
+		{expression: "exists(s2001) OR true", expected: "true"},
+		{expression: "true OR s2001 > 0", expected: "true s2001 0 > OR"},
+		{expression: "false AND exists(s2001)", expected: "false"},
+		{expression: "exists(s2001) AND false OR s6004 > 1", expected: "s6004 1 >"},
+		{expression: "false AND 1 / 0 > 1", expected: "false 1 0 / 1 > AND"},
+		{expression: "1 / 0 > 1 OR true", expected: "1 0 / 1 > true OR"},
+		{expression: "1 / 0 > 1", expected: "1 0 / 1 >"},
+		{expression: "let c = 2 * 3, d = s2001 in d > c", expected: "s2001 d d 6.000000 >"},
+		{expression: `upper("ooo") = "OOO" AND len(name) > 0`, expected: "name len 0 >"},
+		{expression: `date("2022-01-01") < today()`, expected: "2022-01-01 date today <"},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.expression, func(t *testing.T) {
+			t.Parallel()
+
+			values := make([]string, 0)
+			for _, token := range core.Optimize(compileExpression(t, test.expression)) {
+				values = append(values, token.Value)
+			}
+
+			if res := strings.Join(values, " "); res != test.expected {
+				t.Errorf("Optimize() got %s, expected = %s", res, test.expected)
+			}
+		})
+	}
+}
+
+func TestOptimizeKeepsSteps(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "1 + 1 + 1 + 1 + 1 > 0 AND s2001 > 0", Color: RedColor, IsEnable: true},
+	}
+
+	calculate := func(limits Limits, opts ...Option) string {
+		program, err := Compile(formulas, types, append(opts, WithLimits(limits))...)
+		if err != nil {
+			return err.Error()
+		}
+
+		resColor, _, err := program.Calculate(paramsWithOneElement)
+		if err != nil {
+			return err.Error()
+		}
+
+		return string(resColor)
+	}
+
+	// Steps are counted in the parsed formula, so the folded bytecode has the same limit as the interpreter
+	for _, maxSteps := range []int{5, 15} {
+		limits := Limits{MaxSteps: maxSteps}
+
+		if res, explained := calculate(limits), calculate(limits, WithExplain()); res != explained {
+			t.Errorf("Calculate() with MaxSteps = %d got %s, with explain got %s", maxSteps, res, explained)
+		}
+	}
+
+	if res := calculate(Limits{MaxSteps: 15}); res != string(RedColor) {
+		t.Errorf("Calculate() with MaxSteps = 15 got %s, expected = %s", res, RedColor)
+	}
+}
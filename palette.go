@@ -0,0 +1,98 @@
+package calculator
+
+import (
+	"fmt"
+
+	"github.com/egelis/calculator/core"
+)
+
+const (
+	errEmptyPalette    = "palette has no levels"
+	errDuplicateLevel  = "palette level is duplicated"
+	errUnknownDefault  = "default color is not a palette level"
+	errEmptyErrorLevel = "error color is not set"
+	errUnknownColor    = "color is not a palette level"
+	errAggregatedColor = "aggregated color is not a palette level"
+	errAggregatorColor = "aggregator color is not a palette level"
+)
+
+// Palette is a severity scale of formula colors
+type Palette struct {
+	// Levels are ordered from the lowest precedence to the highest
+	Levels []Color
+	// Default is the result color when no formula is triggered
+	Default Color
+	// Error is the result color when the calculation fails
+	Error Color
+}
+
+// DefaultPalette returns the black < grey < green < yellow < red scale
+func DefaultPalette() Palette {
+	return Palette{
+		Levels:  []Color{BlackColor, GreyColor, GreenColor, YellowColor, RedColor},
+		Default: GreyColor,
+		Error:   BlackColor,
+	}
+}
+
+type PaletteError struct {
+	Reason string
+	Color  Color
+}
+
+func (e *PaletteError) Error() string {
+	if e.Color == "" {
+		return fmt.Sprintf("invalid palette: %s", e.Reason)
+	}
+
+	return fmt.Sprintf("invalid palette: %s: %s", e.Reason, e.Color)
+}
+
+type UnknownColorError struct {
+	Formula string
+	Color   Color
+}
+
+func (e *UnknownColorError) Error() string {
+	return fmt.Sprintf("formula '%s': %s: '%s'", e.Formula, errUnknownColor, e.Color)
+}
+
+// precedence returns the index of each level
+func (p Palette) precedence() (map[Color]int, error) {
+	if len(p.Levels) == 0 {
+		return nil, &PaletteError{Reason: errEmptyPalette}
+	}
+
+	res := make(map[Color]int, len(p.Levels))
+
+	for i, level := range p.Levels {
+		if _, ok := res[level]; ok {
+			return nil, &PaletteError{Reason: errDuplicateLevel, Color: level}
+		}
+
+		res[level] = i
+	}
+
+	if _, ok := res[p.Default]; !ok {
+		return nil, &PaletteError{Reason: errUnknownDefault, Color: p.Default}
+	}
+
+	if p.Error == "" {
+		return nil, &PaletteError{Reason: errEmptyErrorLevel}
+	}
+
+	return res, nil
+}
+
+// checkColor checks that the color of the formula is a palette level, scores don't affect the color
+func checkColor(precedence map[Color]int, formula tokenizedFormula) error {
+	if formula.ResultType == core.NUMBER_TYPE {
+		return nil
+	}
+
+	if _, ok := precedence[formula.Color]; !ok {
+		return &UnknownColorError{Formula: formula.Name, Color: formula.Color}
+	}
+
+	return nil
+}
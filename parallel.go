@@ -0,0 +1,92 @@
+package calculator
+
+import (
+	"context"
+	"sync"
+
+	"github.com/egelis/calculator/core"
+)
+
+// calculateParallel calculates sets by a pool of p.workers goroutines.
+// Compiled formulas are only read during the calculation, so they are shared between workers.
+// If several sets fail, the error of the first of them is returned.
+// If it's a context error, results of the leading calculated sets are returned along with it.
+func (p *Program) calculateParallel(ctx context.Context, sources []core.ParamSource) ([]SetResult, error) {
+	var (
+		res  = make([]SetResult, len(sources))
+		done = make([]bool, len(sources))
+		jobs = make(chan int)
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+
+		mu       sync.Mutex
+		errIndex = len(sources)
+		firstErr error
+	)
+
+	workers := p.workers
+	if workers > len(sources) {
+		workers = len(sources)
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				setResult, err := p.calculateSet(ctx, sources[i])
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						close(stop)
+					}
+
+					if i < errIndex {
+						errIndex, firstErr = i, err
+					}
+					mu.Unlock()
+
+					continue
+				}
+
+				res[i], done[i] = setResult, true
+			}
+		}()
+	}
+
+feed:
+	for i := range sources {
+		select {
+		case jobs <- i:
+		case <-stop:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	calculated := 0
+	for calculated < len(done) && done[calculated] {
+		calculated++
+	}
+
+	// Feeding could be stopped by the context
+	if firstErr == nil && calculated < len(sources) {
+		firstErr = ctx.Err()
+	}
+
+	if firstErr == nil {
+		return res, nil
+	}
+
+	if !isContextError(firstErr) {
+		return nil, firstErr
+	}
+
+	return res[:calculated], firstErr
+}
@@ -2,16 +2,15 @@
 package calculator
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/egelis/calculator/core"
-	"github.com/egelis/jparser"
 )
 
 const (
-	errSyntax = "found a syntax error"
-	errCalc   = "calculation failed"
+	errSyntax         = "found a syntax error"
+	errCalc           = "calculation failed"
+	errDuplicateLocal = "local variable is already defined"
 )
 
 type ParseError struct {
@@ -22,21 +21,18 @@ func (e *ParseError) Error() string {
 	return fmt.Sprintf("error: %s", e.Reason)
 }
 
+// parser checks the syntax of the tokenized formula, it doesn't depend on parameters
 type parser struct {
-	rawSet jparser.RawMessageSet
 	tokens []core.Token
 
 	tokensSize        int
 	it                int
 	calculationTokens []core.Token
+	locals            []string
 }
 
-func newParser(
-	tokens []core.Token,
-	rawSet jparser.RawMessageSet,
-) *parser {
+func newParser(tokens []core.Token) *parser {
 	return &parser{
-		rawSet:            rawSet,
 		tokens:            tokens,
 		tokensSize:        len(tokens),
 		it:                -1,
@@ -44,52 +40,107 @@ func newParser(
 	}
 }
 
-// START: LOG_EXP
+// START: [LET_BLOCK] => LOG_EXP
+
+// LET_BLOCK: 'let' => BINDING => {',' => BINDING} => 'in'
+// BINDING: IDENT => '=' => LOG_EXP
 
 // LOG_EXP: LOG_TERM => {LOG_OP | COMP_OP => LOG_TERM}
-// LOG_TERM: BOOL | EXISTS | ARITH_EXP | ( "(" => LOG_EXP => ")" )
+// LOG_TERM: BOOL | ARITH_EXP | ( "(" => LOG_EXP => ")" )
 
 // ARITH_EXP: ARITH_TERM => {ARITH_OP => ARITH_TERM}
-// ARITH_TERM: NUM | IDENT ( "(" => ARITH_EXP => ")" )
+// ARITH_TERM: NUM | STRING | IDENT | FORMULA_REF | FUNC_CALL | ( "(" => ARITH_EXP => ")" )
 
-// EXISTS: 'exists' => '(' => IDENT => ')'
+// FORMULA_REF: '@' => NAME
+// FUNC_CALL: FUNC => '(' => [LOG_EXP => {',' => LOG_EXP}] => ')'
 
 // Конечные:
 // BOOL: true, false
 // LOG_OP: AND, OR
 // COMP_OP: > < != = >= <=
 // NUM: 2.45, 2
-// IDENT: param_123, denmt123
+// STRING: "64.19"
+// IDENT: param_123, denmt123, company.founder.inn
+// FUNC: exists, number, string, bool, date ...
+
+// START: [LET_BLOCK] => LOGIC_EXP
+// start checks the syntax and returns tokens of the formula in the infix notation without let keywords
+func (p *parser) start() ([]core.Token, error) {
+	savedIt := p.it
+	if !p.checkNext(p.LetBlock) {
+		p.it = savedIt
+		p.locals = nil
+		p.calculationTokens = p.calculationTokens[:0]
+	}
 
-// START: LOGIC_EXP
-func (p *parser) start() (core.Token, error) {
 	if !p.checkNext(p.LogicExp) {
 		// TODO: уточнить ошибку
-		return core.Token{}, &ParseError{Reason: errSyntax}
+		return nil, &ParseError{Reason: errSyntax}
 	}
 
 	// Если остались неразобранные токены, то они не подошли под правила
 	if p.it+1 != p.tokensSize {
 		// TODO: уточнить ошибку
-		return core.Token{}, &ParseError{Reason: errSyntax}
+		return nil, &ParseError{Reason: errSyntax}
 	}
 
-	// Result calculation
-	res, err := core.Calculate(p.calculationTokens, p.rawSet)
-	if err != nil {
-		var paramErr *core.UnknownParameterError
-		if errors.As(err, &paramErr) {
-			return core.Token{
-				Type:      core.BOOL,
-				Value:     "false",
-				ValueType: core.BOOL_TYPE,
-			}, nil
+	if name, ok := p.duplicateLocal(); ok {
+		return nil, &ParseError{Reason: fmt.Sprintf("%s: %s", errDuplicateLocal, name)}
+	}
+
+	return p.calculationTokens, nil
+}
+
+// LET_BLOCK: 'let' => BINDING => {',' => BINDING} => 'in'
+func (p *parser) LetBlock() bool {
+	if !p.checkNext(p.Let) {
+		return false
+	}
+
+	if !p.checkNext(p.Binding) {
+		return false
+	}
+
+	for {
+		savedIt := p.it
+
+		if !p.checkNext(p.Comma) {
+			p.it = savedIt
+			break
 		}
 
-		return core.Token{}, &ParseError{Reason: fmt.Sprintf("%s: %s", errCalc, err)}
+		if !p.checkNext(p.Binding) {
+			return false
+		}
+	}
+
+	return p.checkNext(p.In)
+}
+
+// BINDING: IDENT => '=' => LOGIC_EXP
+func (p *parser) Binding() bool {
+	if !p.checkNext(p.Ident) {
+		return false
 	}
 
-	return res, nil
+	name := p.tokens[p.it].Value
+
+	if !p.checkNext(p.Assign) {
+		return false
+	}
+
+	if !p.checkNext(p.LogicExp) {
+		return false
+	}
+
+	// The value is calculated once and then referenced by name
+	p.locals = append(p.locals, name)
+	p.calculationTokens = append(p.calculationTokens, core.Token{
+		Type:  core.ASSIGN,
+		Value: name,
+	})
+
+	return true
 }
 
 // LOGIC_EXP: LOGIC_TERM => {LOG_OP | COMP_OP => LOGIC_TERM}
@@ -115,6 +166,8 @@ func (p *parser) LogicExp() bool {
 
 		if !p.checkNext(p.LogicTerm) {
 			p.it = savedIt
+			p.calculationTokens = p.calculationTokens[:len(p.calculationTokens)-1]
+
 			break
 		}
 	}
@@ -122,42 +175,33 @@ func (p *parser) LogicExp() bool {
 	return true
 }
 
-// LOGIC_TERM: BOOL | EXISTS | ARITH_EXP | ( "(" => LOGIC_EXP => ")" )
+// LOGIC_TERM: BOOL | ARITH_EXP | ( "(" => LOGIC_EXP => ")" )
 func (p *parser) LogicTerm() bool {
 	savedIt := p.it
 
 	if !p.checkNext(p.Bool) {
 		p.it = savedIt
 
-		if !p.checkNext(p.ExistsFunc) {
+		if !p.checkNext(p.ArithmeticExp) {
 			p.it = savedIt
 
-			startIt := p.it
-
-			if !p.checkNext(p.ArithmeticExp) {
-				p.it = savedIt
-
-				if !p.checkNext(p.LBracket) {
-					return false
-				}
-
-				// add bracket
-				p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+			if !p.checkNext(p.LBracket) {
+				return false
+			}
 
-				if !p.checkNext(p.LogicExp) {
-					return false
-				}
+			// add bracket
+			p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
 
-				if !p.checkNext(p.RBracket) {
-					return false
-				}
+			if !p.checkNext(p.LogicExp) {
+				return false
+			}
 
-				// add bracket
-				p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
-			} else {
-				// Add arithmetic expression
-				p.calculationTokens = append(p.calculationTokens, p.tokens[startIt+1:p.it+1]...)
+			if !p.checkNext(p.RBracket) {
+				return false
 			}
+
+			// add bracket
+			p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
 		}
 	} else {
 		// Add bool
@@ -181,8 +225,13 @@ func (p *parser) ArithmeticExp() bool {
 			break
 		}
 
+		// Add arithmetic operator
+		p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+
 		if !p.checkNext(p.ArithmeticTerm) {
 			p.it = savedIt
+			p.calculationTokens = p.calculationTokens[:len(p.calculationTokens)-1]
+
 			break
 		}
 	}
@@ -190,68 +239,115 @@ func (p *parser) ArithmeticExp() bool {
 	return true
 }
 
-// ARITH_TERM: NUM | IDENT | ( "(" => ARITH_EXP => ")" )
+// ARITH_TERM: NUM | STRING | IDENT | FORMULA_REF | FUNC_CALL | ( "(" => ARITH_EXP => ")" )
 func (p *parser) ArithmeticTerm() bool {
 	savedIt := p.it
-	if !p.checkNext(p.Num) {
+	if p.checkNext(p.FuncCall) {
+		return true
+	}
+
+	p.it = savedIt
+	if !p.checkNext(p.Operand) {
 		p.it = savedIt
 
-		if !p.checkNext(p.Ident) {
-			p.it = savedIt
+		if !p.checkNext(p.LBracket) {
+			return false
+		}
 
-			if !p.checkNext(p.LBracket) {
-				return false
-			}
+		// add bracket
+		p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
 
-			if !p.checkNext(p.ArithmeticExp) {
-				return false
-			}
+		if !p.checkNext(p.ArithmeticExp) {
+			return false
+		}
 
-			if !p.checkNext(p.RBracket) {
-				return false
-			}
+		if !p.checkNext(p.RBracket) {
+			return false
 		}
 	}
 
+	// add operand or bracket
+	p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+
 	return true
 }
 
-// EXISTS: 'exists' -> '(' -> IDENT -> ')'
-func (p *parser) ExistsFunc() bool {
-	if !p.checkNext(p.IdentExists) {
+// FUNC_CALL: FUNC => '(' => [LOG_EXP => {',' => LOG_EXP}] => ')'
+func (p *parser) FuncCall() bool {
+	if !p.checkNext(p.Func) {
 		return false
 	}
 
+	// The token is copied, so the number of arguments doesn't change tokens of the formula
+	funcIt := len(p.calculationTokens)
+	p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+
 	if !p.checkNext(p.LBracket) {
 		return false
 	}
 
-	if !p.checkNext(p.Ident) {
-		return false
-	}
+	p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
 
-	field := p.tokens[p.it].Value
+	args := 0
+
+	savedIt := p.it
+	if p.checkNext(p.LogicExp) {
+		args++
+
+		for {
+			savedIt = p.it
+
+			if !p.checkNext(p.Comma) {
+				p.it = savedIt
+				break
+			}
+
+			p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+
+			if !p.checkNext(p.LogicExp) {
+				return false
+			}
+
+			args++
+		}
+	} else {
+		p.it = savedIt
+	}
 
 	if !p.checkNext(p.RBracket) {
 		return false
 	}
 
-	_, ok := p.rawSet[field]
-	p.calculationTokens = append(p.calculationTokens, core.Token{
-		Type:      core.BOOL,
-		Value:     fmt.Sprintf("%t", ok),
-		ValueType: core.BOOL_TYPE,
-	})
+	p.calculationTokens = append(p.calculationTokens, p.tokens[p.it])
+	p.calculationTokens[funcIt].Args = args
 
 	return true
 }
 
 // Нетерминалы
 
-func (p *parser) IdentExists() bool {
+func (p *parser) Let() bool {
+	p.it++
+
+	return p.tokens[p.it].Type == core.LET
+}
+
+func (p *parser) In() bool {
+	p.it++
+
+	return p.tokens[p.it].Type == core.IN
+}
+
+func (p *parser) Comma() bool {
 	p.it++
 
-	return p.tokens[p.it].Type == core.EXISTS_FUNC
+	return p.tokens[p.it].Type == core.COMMA
+}
+
+func (p *parser) Assign() bool {
+	p.it++
+
+	return p.tokens[p.it].Type == core.COMP_OP && p.tokens[p.it].Value == "="
 }
 
 func (p *parser) CompOperator() bool {
@@ -284,10 +380,22 @@ func (p *parser) Bool() bool {
 	return p.tokens[p.it].Type == core.BOOL
 }
 
-func (p *parser) Num() bool {
+func (p *parser) Func() bool {
 	p.it++
 
-	return p.tokens[p.it].Type == core.NUMBER
+	return p.tokens[p.it].Type == core.FUNC
+}
+
+// Operand is NUM, STRING, IDENT or FORMULA_REF
+func (p *parser) Operand() bool {
+	p.it++
+
+	switch p.tokens[p.it].Type { // nolint:exhaustive
+	case core.NUMBER, core.STRING, core.IDENT, core.FORMULA_REF:
+		return true
+	default:
+		return false
+	}
 }
 
 func (p *parser) Ident() bool {
@@ -302,10 +410,94 @@ func (p *parser) ArithmeticOp() bool {
 	return p.tokens[p.it].Type == core.ARITH_OP
 }
 
+func (p *parser) duplicateLocal() (string, bool) {
+	seen := make(map[string]struct{}, len(p.locals))
+
+	for _, name := range p.locals {
+		if _, ok := seen[name]; ok {
+			return name, true
+		}
+
+		seen[name] = struct{}{}
+	}
+
+	return "", false
+}
+
+// checkNext applies the rule to the next tokens, calculation tokens added by a failed rule are discarded
 func (p *parser) checkNext(f func() bool) bool {
+	calculated := len(p.calculationTokens)
+
 	if p.it+1 < p.tokensSize && f() {
 		return true
 	}
 
+	p.calculationTokens = p.calculationTokens[:calculated]
+
 	return false
 }
+
+// TypeCheckError is returned when the parsed formula fails checks of Compile, e.g. of types or patterns
+type TypeCheckError struct {
+	Formula string
+	Err     error
+}
+
+func (e *TypeCheckError) Error() string {
+	return fmt.Sprintf("formula '%s': %s", e.Formula, e.Err)
+}
+
+func (e *TypeCheckError) Unwrap() error {
+	return e.Err
+}
+
+// compileFormula parses the formula once for all sets of parameters and checks the result with compilePostfix
+func compileFormula(formula *tokenizedFormula, limits Limits) error {
+	infix, err := newParser(formula.Tokens).start()
+	if err != nil {
+		return err
+	}
+
+	exp, err := core.ToPostfixExp(infix)
+	if err != nil {
+		return err
+	}
+
+	formula.Postfix = exp
+
+	return compilePostfix(formula, limits)
+}
+
+// compilePostfix checks limits of the parsed formula, types of function arguments and of the result,
+// compiles regular expressions and optimizes the formula, see Program.compileBytecode
+func compilePostfix(formula *tokenizedFormula, limits Limits) error {
+	if err := limits.checkPostfix(formula.Name, formula.Postfix); err != nil {
+		return err
+	}
+
+	resultType, err := core.CheckTypes(formula.Postfix)
+	if err != nil {
+		return &TypeCheckError{Formula: formula.Name, Err: err}
+	}
+
+	if resultType != core.UNKNOWN_TYPE && resultType != formula.ResultType {
+		return &ResultTypeError{Formula: formula.Name, Expected: formula.ResultType, Got: resultType}
+	}
+
+	formula.Patterns = core.Patterns{}
+	if err := core.CompilePatterns(formula.Postfix, formula.Patterns); err != nil {
+		return &TypeCheckError{Formula: formula.Name, Err: err}
+	}
+
+	optimized := core.Optimize(formula.Postfix)
+	if len(optimized) == 1 && optimized[0].Type == core.BOOL {
+		formula.Warnings = append(formula.Warnings, Warning{
+			Formula: formula.Name,
+			Reason:  fmt.Sprintf("%s %s", warnConstant, optimized[0].Value),
+		})
+	}
+
+	formula.Optimized = optimized
+
+	return nil
+}
@@ -0,0 +1,408 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/egelis/calculator/core"
+	"github.com/egelis/jparser"
+)
+
+// Program is a compiled set of formulas which can be calculated for any number of parameter sets
+type Program struct {
+	// formulas are sorted in calculation order, declared keep the order of Compile arguments
+	formulas   []tokenizedFormula
+	declared   []tokenizedFormula
+	palette    Palette
+	precedence map[Color]int
+	aggregator Aggregator
+	explain    bool
+	workers    int
+	limits     Limits
+	lenient    bool
+	location   *time.Location
+	paramTypes map[string]core.ValueType
+	// patterns are compiled literal patterns of all formulas
+	patterns core.Patterns
+	// shared are subexpressions of several formulas in the order of evaluation
+	shared      []sharedExpression
+	sharedIndex map[string]int
+
+	strictParams bool
+}
+
+type Option func(p *Program)
+
+// WithPalette replaces the default color palette
+func WithPalette(palette Palette) Option {
+	return func(p *Program) {
+		p.palette = palette
+	}
+}
+
+// WithAggregator replaces the default WorstOf aggregation of formula colors
+func WithAggregator(aggregator Aggregator) Option {
+	return func(p *Program) {
+		p.aggregator = aggregator
+	}
+}
+
+// WithExplain adds traces of the calculation to each formula Value
+func WithExplain() Option {
+	return func(p *Program) {
+		p.explain = true
+	}
+}
+
+// WithWorkers calculates sets of parameters concurrently by 'workers' goroutines.
+// The order of results doesn't change.
+func WithWorkers(workers int) Option {
+	return func(p *Program) {
+		p.workers = workers
+	}
+}
+
+// WithLimits sets limits of the size and the evaluation cost of formulas
+func WithLimits(limits Limits) Option {
+	return func(p *Program) {
+		p.limits = limits
+	}
+}
+
+// WithLenientTypes converts operands of operators to the same type if possible,
+// e.g. numbers arriving as JSON strings like "1500" are compared with numbers
+func WithLenientTypes() Option {
+	return func(p *Program) {
+		p.lenient = true
+	}
+}
+
+// WithLocation sets the time zone of date functions and of dates without a time zone, UTC by default
+func WithLocation(location *time.Location) Option {
+	return func(p *Program) {
+		p.location = location
+	}
+}
+
+type locationKey struct{}
+
+// ContextWithLocation sets the time zone of calculations with the returned context,
+// it replaces the time zone of the WithLocation option for one call of CalculateContext, Stream, etc.
+func ContextWithLocation(ctx context.Context, location *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, location)
+}
+
+// Compile tokenizes enabled formulas and checks that they are consistent with each other and with the palette
+func Compile(formulas []Formula, paramTypes map[string]core.ValueType, opts ...Option) (*Program, error) {
+	program := &Program{
+		palette:    DefaultPalette(),
+		aggregator: WorstOf(),
+		paramTypes: paramTypes,
+	}
+
+	for _, opt := range opts {
+		opt(program)
+	}
+
+	precedence, err := program.palette.precedence()
+	if err != nil {
+		return nil, err
+	}
+
+	program.precedence = precedence
+
+	if err := checkAggregatorColors(program.aggregator, precedence); err != nil {
+		return nil, err
+	}
+
+	tokenizedFormulas, err := getTokenizedFormulas(formulas, paramTypes, program.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, formula := range tokenizedFormulas {
+		if err := checkColor(precedence, formula); err != nil {
+			return nil, err
+		}
+
+		if err := compileFormula(&tokenizedFormulas[i], program.limits); err != nil {
+			return nil, err
+		}
+
+		if program.strictParams {
+			if err := checkParams(formula); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := program.compileBytecode(tokenizedFormulas); err != nil {
+		return nil, err
+	}
+
+	orderedFormulas, err := orderFormulas(tokenizedFormulas)
+	if err != nil {
+		return nil, err
+	}
+
+	program.formulas = orderedFormulas
+	program.declared = tokenizedFormulas
+
+	return program, nil
+}
+
+const warnConstant = "formula is always"
+
+// Warning is a problem of a formula found by Compile which doesn't prevent its calculation
+type Warning struct {
+	Formula string
+	Reason  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("formula '%s': %s", w.Formula, w.Reason)
+}
+
+// Warnings returns warnings of formulas in the order of Compile arguments,
+// e.g. about formulas which are always true or always false
+func (p *Program) Warnings() []Warning {
+	var res []Warning
+	for _, formula := range p.declared {
+		res = append(res, formula.Warnings...)
+	}
+
+	return res
+}
+
+// SetResult is the result of all formulas for one set of parameters
+type SetResult struct {
+	// Color is chosen by the aggregator of the program, by default it is the highest color among true formulas
+	Color Color `json:"color"`
+	// Triggered are names of formulas which determined the color
+	Triggered []string      `json:"triggered"`
+	Formulas  FormulaResult `json:"formulas"`
+}
+
+// Calculate calculates each formula for each set of parameters from 'rawSets'
+func (p *Program) Calculate(rawSets []jparser.RawMessageSet) (Color, []FormulaResult, error) {
+	return p.CalculateContext(context.Background(), rawSets)
+}
+
+// CalculateContext calculates formulas like Calculate and stops when the context is done.
+// In this case results of already calculated sets are returned along with ctx.Err().
+func (p *Program) CalculateContext(ctx context.Context, rawSets []jparser.RawMessageSet,
+) (Color, []FormulaResult, error) {
+	resColor, setResults, err := p.CalculateSetsContext(ctx, rawSets)
+	if err != nil && !isContextError(err) {
+		return resColor, nil, err
+	}
+
+	formulaResults := make([]FormulaResult, 0, len(setResults))
+	for _, setResult := range setResults {
+		formulaResults = append(formulaResults, setResult.Formulas)
+	}
+
+	return resColor, formulaResults, err
+}
+
+// CalculateSets calculates each formula for each set of parameters from 'rawSets'.
+// Unlike Calculate it returns the color of each set along with the global worst-case color.
+func (p *Program) CalculateSets(rawSets []jparser.RawMessageSet) (Color, []SetResult, error) {
+	return p.CalculateSetsContext(context.Background(), rawSets)
+}
+
+// CalculateSetsContext calculates formulas like CalculateSets and stops when the context is done.
+// In this case results of already calculated sets are returned along with ctx.Err().
+func (p *Program) CalculateSetsContext(ctx context.Context, rawSets []jparser.RawMessageSet,
+) (Color, []SetResult, error) {
+	sources := make([]core.ParamSource, 0, len(rawSets))
+	for _, rawSet := range rawSets {
+		sources = append(sources, core.RawSource(rawSet))
+	}
+
+	return p.CalculateSourcesContext(ctx, sources)
+}
+
+// CalculateSources calculates formulas like CalculateSets taking parameters from 'sources'
+func (p *Program) CalculateSources(sources []core.ParamSource) (Color, []SetResult, error) {
+	return p.CalculateSourcesContext(context.Background(), sources)
+}
+
+// CalculateSourcesContext calculates formulas like CalculateSetsContext taking parameters from 'sources'
+func (p *Program) CalculateSourcesContext(ctx context.Context, sources []core.ParamSource,
+) (Color, []SetResult, error) {
+	// For the situation where we have formulas without rawSet
+	if len(sources) == 0 {
+		sources = []core.ParamSource{core.RawSource(nil)}
+	}
+
+	var (
+		calculated []SetResult
+		err        error
+	)
+
+	if p.workers > 1 && len(sources) > 1 {
+		calculated, err = p.calculateParallel(ctx, sources)
+	} else {
+		calculated, err = p.calculateSequential(ctx, sources)
+	}
+
+	if err != nil && !isContextError(err) {
+		return p.palette.Error, nil, err
+	}
+
+	setResults := make([]SetResult, 0, len(calculated))
+	resColor := p.palette.Default
+
+	for _, setResult := range calculated {
+		if len(setResult.Formulas) == 0 {
+			continue
+		}
+
+		if p.precedence[setResult.Color] > p.precedence[resColor] {
+			resColor = setResult.Color
+		}
+
+		setResults = append(setResults, setResult)
+	}
+
+	return resColor, setResults, err
+}
+
+// calculateSequential returns results of sets calculated before the context is done along with ctx.Err()
+func (p *Program) calculateSequential(ctx context.Context, sources []core.ParamSource) ([]SetResult, error) {
+	res := make([]SetResult, 0, len(sources))
+
+	for _, source := range sources {
+		setResult, err := p.calculateSet(ctx, source)
+		if isContextError(err) {
+			return res, err
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, setResult)
+	}
+
+	return res, nil
+}
+
+func (p *Program) calculateSet(ctx context.Context, source core.ParamSource) (SetResult, error) {
+	result := FormulaResult{}
+
+	refs := result.resolveRef
+	if len(p.shared) > 0 {
+		refs = p.newSetValues(ctx, source, result).resolve
+	}
+
+	for _, formula := range p.formulas {
+		resToken, traces, err := p.evaluateFormula(ctx, formula, source, refs)
+
+		var paramErr *core.UnknownParameterError
+		if err != nil && !errors.As(err, &paramErr) {
+			return SetResult{}, err
+		}
+
+		resValue, err := newValue(formula, resToken)
+		if err != nil {
+			return SetResult{}, err
+		}
+
+		if p.explain {
+			resValue.Trace = traces
+			if paramErr != nil {
+				// A score without value is null like in references to it
+				display := "null"
+				if formula.ResultType == core.BOOL_TYPE {
+					display = strconv.FormatBool(resValue.Result)
+				}
+
+				resValue.Trace = []*core.Trace{{Expr: paramErr.Error(), Value: display}}
+			}
+		}
+
+		result[formula.Name] = resValue
+	}
+
+	outcomes := make([]Outcome, 0, len(p.declared))
+	for _, formula := range p.declared {
+		outcomes = append(outcomes, Outcome{
+			Name:   formula.Name,
+			Color:  formula.Color,
+			Weight: formula.Weight,
+			Value:  result[formula.Name],
+		})
+	}
+
+	resColor, triggered := p.aggregator.Aggregate(p.palette, outcomes)
+	if _, ok := p.precedence[resColor]; !ok {
+		return SetResult{}, &PaletteError{Reason: errAggregatedColor, Color: resColor}
+	}
+
+	return SetResult{
+		Color:     resColor,
+		Triggered: triggered,
+		Formulas:  result,
+	}, nil
+}
+
+// evaluateFormula evaluates the parsed formula for one set of parameters, results of referenced formulas
+// are resolved by 'refs'. The bytecode is evaluated unless traces are needed,
+// they are recorded by the interpreter of the postfix.
+func (p *Program) evaluateFormula(ctx context.Context, formula tokenizedFormula, source core.ParamSource,
+	refs core.RefResolver,
+) (core.Token, []*core.Trace, error) {
+	opts := p.options(ctx)
+
+	var (
+		res    core.Token
+		traces []*core.Trace
+		err    error
+	)
+
+	if p.explain {
+		opts.Refs = refs
+		res, traces, err = core.EvaluateWithOptions(ctx, formula.Postfix, source, opts)
+	} else {
+		res, err = formula.Bytecode.Evaluate(ctx, source, refs, opts)
+	}
+
+	if err != nil {
+		var (
+			paramErr   *core.UnknownParameterError
+			sourceErr  *core.ParamSourceError
+			formulaErr *UnknownFormulaError
+		)
+		if isContextError(err) || errors.As(err, &paramErr) || errors.As(err, &sourceErr) || errors.As(err, &formulaErr) {
+			return core.Token{}, nil, err
+		}
+
+		return core.Token{}, nil, &ParseError{Reason: fmt.Sprintf("%s: %s", errCalc, err)}
+	}
+
+	return res, traces, nil
+}
+
+// options are options of the evaluation of formulas, the time zone may be set by ContextWithLocation
+func (p *Program) options(ctx context.Context) core.Options {
+	location := p.location
+	if ctxLocation, ok := ctx.Value(locationKey{}).(*time.Location); ok && ctxLocation != nil {
+		location = ctxLocation
+	}
+
+	return core.Options{
+		Trace:    p.explain,
+		Lenient:  p.lenient,
+		Location: location,
+		Patterns: p.patterns,
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
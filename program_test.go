@@ -0,0 +1,913 @@
+// nolint:gochecknoglobals,revive
+package calculator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/egelis/calculator/core"
+	"github.com/egelis/jparser"
+)
+
+var severityPalette = Palette{
+	Levels:  []Color{"none", "low", "medium", "high", "critical"},
+	Default: "none",
+	Error:   "failed",
+}
+
+func TestProgramPalette(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "s2001 > 0", Color: "medium", IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 > 1000000", Color: "high", IsEnable: true},
+		{Name: "formula_3", Expression: "s2001 < 0", Color: "critical", IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types, WithPalette(severityPalette))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, _, err := program.Calculate(paramsWithOneElement)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != "high" {
+		t.Errorf("Calculate() got resColor = %s, expected = high", resColor)
+	}
+
+	resColor, _, err = program.Calculate(paramsWithMultipleElements)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != "critical" {
+		t.Errorf("Calculate() got resColor = %s, expected = critical", resColor)
+	}
+}
+
+func TestCompilePaletteErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		palette    Palette
+		formulas   []Formula
+		aggregator Aggregator
+	}{
+		{
+			name:    "color out of palette",
+			palette: severityPalette,
+			formulas: []Formula{
+				{Name: "formula_1", Expression: "true", Color: RedColor, IsEnable: true},
+			},
+		},
+		{
+			name:    "empty palette",
+			palette: Palette{Default: "none", Error: "failed"},
+		},
+		{
+			name: "default color out of palette",
+			palette: Palette{
+				Levels:  []Color{"low", "high"},
+				Default: "none",
+				Error:   "failed",
+			},
+		},
+		{
+			name: "duplicated level",
+			palette: Palette{
+				Levels:  []Color{"low", "high", "low"},
+				Default: "low",
+				Error:   "failed",
+			},
+		},
+		{
+			name:       "band color out of palette",
+			palette:    severityPalette,
+			aggregator: WeightedScore([]Band{{Min: 1, Color: "high"}, {Min: 5, Color: RedColor}}),
+		},
+		{
+			name:       "threshold color out of palette",
+			palette:    severityPalette,
+			aggregator: Threshold(map[Color]int{"high": 2, RedColor: 1}),
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			opts := []Option{WithPalette(test.palette)}
+			if test.aggregator != nil {
+				opts = append(opts, WithAggregator(test.aggregator))
+			}
+
+			_, err := Compile(test.formulas, nil, opts...)
+
+			var (
+				colorErr   *UnknownColorError
+				paletteErr *PaletteError
+			)
+			if !errors.As(err, &colorErr) && !errors.As(err, &paletteErr) {
+				t.Errorf("Compile() got error = \"%v\", expected palette error", err)
+			}
+		})
+	}
+}
+
+func TestCompileScoresWithoutColor(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{{Name: "score", Expression: "1 + 2", IsEnable: true, ResultType: core.NUMBER_TYPE}}
+
+	if _, err := Compile(formulas, nil, WithPalette(severityPalette)); err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, res, err := Calculate(formulas, nil, nil)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != GreyColor {
+		t.Errorf("Calculate() got resColor = %s, expected = %s", resColor, GreyColor)
+	}
+
+	if number := res[0]["score"].Number; number == nil || *number != 3 {
+		t.Errorf("Calculate() got score = %v, expected = 3", number)
+	}
+}
+
+func TestProgramCalculateSets(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "bool_param = false", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 < 0", Color: YellowColor, Version: 1, IsEnable: true},
+		{Name: "formula_3", Expression: "s2001 >= 0 AND exists(s6004)", Color: RedColor, Version: 2, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, setResults, err := program.CalculateSets(paramsWithMultipleElements)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor {
+		t.Errorf("CalculateSets() got resColor = %s, expected = %s", resColor, RedColor)
+	}
+
+	expected := []struct {
+		color     Color
+		triggered []string
+	}{
+		{color: GreenColor, triggered: []string{"formula_1"}},
+		{color: RedColor, triggered: []string{"formula_3"}},
+		{color: YellowColor, triggered: []string{"formula_2"}},
+	}
+
+	if len(setResults) != len(expected) {
+		t.Fatalf("CalculateSets() got %d results, expected = %d", len(setResults), len(expected))
+	}
+
+	for i, setResult := range setResults {
+		if setResult.Color != expected[i].color {
+			t.Errorf("CalculateSets() got color[%d] = %s, expected = %s", i, setResult.Color, expected[i].color)
+		}
+
+		if !reflect.DeepEqual(setResult.Triggered, expected[i].triggered) {
+			t.Errorf("CalculateSets() got triggered[%d] = %v, expected = %v", i, setResult.Triggered, expected[i].triggered)
+		}
+	}
+}
+
+func TestCompileParsesOnce(t *testing.T) {
+	t.Parallel()
+
+	_, err := Compile([]Formula{{Name: "formula_1", Expression: "s2001 > AND", Color: RedColor, IsEnable: true}}, types)
+
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Errorf("Compile() got error = \"%v\", expected ParseError", err)
+	}
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "exists(s6004)", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "@formula_1 = false", Color: RedColor, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	rawSets := []jparser.RawMessageSet{
+		{"s6004": json.RawMessage(`1`)},
+		{},
+		{"s6004": json.RawMessage(`2`)},
+	}
+	expected := []Color{GreenColor, RedColor, GreenColor}
+
+	_, setResults, err := program.CalculateSets(rawSets)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	for i, setResult := range setResults {
+		if setResult.Color != expected[i] {
+			t.Errorf("CalculateSets() got color[%d] = %s, expected = %s", i, setResult.Color, expected[i])
+		}
+	}
+}
+
+func TestProgramWarnings(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "1=2 AND 1=1 OR 1=1", Color: RedColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 > 0 AND true", Color: YellowColor, IsEnable: true},
+		{Name: "formula_3", Expression: "let x = 5 in x < 2 AND true", Color: GreenColor, IsEnable: true},
+		{Name: "formula_4", Expression: "1=2 AND s2001 > 0", Color: RedColor, IsEnable: true},
+		{Name: "formula_5", Expression: "s2001 > 0 AND false", Color: YellowColor, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	expected := []Warning{
+		{Formula: "formula_1", Reason: "formula is always true"},
+		{Formula: "formula_3", Reason: "formula is always false"},
+		{Formula: "formula_4", Reason: "formula is always false"},
+		{Formula: "formula_5", Reason: "formula is always false"},
+	}
+
+	if warnings := program.Warnings(); !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Warnings() got %v, expected = %v", warnings, expected)
+	}
+
+	resColor, _, err := program.Calculate(paramsWithOneElement)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor {
+		t.Errorf("Calculate() got resColor = %s, expected = %s", resColor, RedColor)
+	}
+}
+
+func TestProgramSharedSubexpressions(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "s2001 / s6004 > 1 AND exists(s6004)", Color: RedColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 / s6004 < 0 OR exists(s6004)", Color: YellowColor, IsEnable: true},
+		{Name: "formula_3", Expression: "let x = s2001 / s6004 in x = 0", Color: GreenColor, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	expectedDump := strings.Join([]string{
+		"$1 = s2001 / s6004",
+		"$2 = exists(s6004)",
+		"formula_1 = ($1 > 1) AND $2",
+		"formula_2 = ($1 < 0) OR $2",
+		"formula_3 = let x = $1 in x = 0",
+	}, "\n")
+
+	if dump := program.Dump(); dump != expectedDump {
+		t.Errorf("Dump() got\n%s\nexpected\n%s", dump, expectedDump)
+	}
+
+	var lookups int
+
+	source := core.ParamSourceFunc(func(name string) (any, bool, error) {
+		lookups++
+
+		return map[string]any{"s2001": 100.0, "s6004": 10.0}[name], true, nil
+	})
+
+	resColor, setResults, err := program.CalculateSources([]core.ParamSource{source})
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	// s2001 and s6004 for $1 and s6004 for $2
+	if lookups != 3 {
+		t.Errorf("CalculateSources() got %d lookups, expected = 3", lookups)
+	}
+
+	explained, err := Compile(formulas, types, WithExplain())
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	expectedColor, expectedRes, err := explained.CalculateSources([]core.ParamSource{source})
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != expectedColor || len(setResults) != 1 || len(expectedRes) != 1 {
+		t.Fatalf("CalculateSources() got resColor = %s, expected = %s", resColor, expectedColor)
+	}
+
+	for name, value := range expectedRes[0].Formulas {
+		value.Trace = nil
+		if !reflect.DeepEqual(setResults[0].Formulas[name], value) {
+			t.Errorf("CalculateSources() got %s = %v, expected = %v", name, setResults[0].Formulas[name], value)
+		}
+	}
+
+	expectedDump = strings.Join([]string{
+		"formula_1 = ((s2001 / s6004) > 1) AND exists(s6004)",
+		"formula_2 = ((s2001 / s6004) < 0) OR exists(s6004)",
+		"formula_3 = let x = s2001 / s6004 in x = 0",
+	}, "\n")
+
+	if dump := explained.Dump(); dump != expectedDump {
+		t.Errorf("Dump() WithExplain got\n%s\nexpected\n%s", dump, expectedDump)
+	}
+
+	// Steps of shared subexpressions are counted in each formula using them
+	_, err = Compile(formulas, types, WithLimits(Limits{MaxSteps: 7}))
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Formula != "formula_1" || limitErr.Actual != 8 {
+		t.Errorf("Compile() got error = \"%v\", expected LimitError of formula_1 with 8 steps", err)
+	}
+}
+
+func TestProgramAggregators(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "s2001 > 0", Color: GreenColor, Weight: 1, IsEnable: true},
+		{Name: "formula_2", Expression: "s6004 > 0", Color: YellowColor, Weight: 2, IsEnable: true},
+		{Name: "formula_3", Expression: "stated_capital > 0", Color: RedColor, Weight: 0.5, IsEnable: true},
+		{Name: "formula_4", Expression: "s2001 < 0", Color: RedColor, Weight: 10, IsEnable: true},
+	}
+
+	tests := []struct {
+		name              string
+		aggregator        Aggregator
+		expectedColor     Color
+		expectedTriggered []string
+	}{
+		{
+			name:              "worst of",
+			aggregator:        WorstOf(),
+			expectedColor:     RedColor,
+			expectedTriggered: []string{"formula_3"},
+		},
+		{
+			name:              "first match",
+			aggregator:        FirstMatch(),
+			expectedColor:     GreenColor,
+			expectedTriggered: []string{"formula_1"},
+		},
+		{
+			name:              "weighted score",
+			aggregator:        WeightedScore([]Band{{Min: 5, Color: RedColor}, {Min: 2, Color: YellowColor}}),
+			expectedColor:     YellowColor,
+			expectedTriggered: []string{"formula_1", "formula_2", "formula_3"},
+		},
+		{
+			name:              "threshold",
+			aggregator:        Threshold(map[Color]int{RedColor: 2}),
+			expectedColor:     YellowColor,
+			expectedTriggered: []string{"formula_2"},
+		},
+		{
+			name: "custom",
+			aggregator: AggregatorFunc(func(palette Palette, outcomes []Outcome) (Color, []string) {
+				return palette.Levels[len(palette.Levels)-1], nil
+			}),
+			expectedColor: RedColor,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			program, err := Compile(formulas, types, WithAggregator(test.aggregator))
+			if err != nil {
+				t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+			}
+
+			resColor, setResults, err := program.CalculateSets(paramsWithOneElement)
+			if err != nil {
+				t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+			}
+
+			if resColor != test.expectedColor {
+				t.Errorf("CalculateSets() got resColor = %s, expected = %s", resColor, test.expectedColor)
+			}
+
+			if !reflect.DeepEqual(setResults[0].Triggered, test.expectedTriggered) {
+				t.Errorf("CalculateSets() got triggered = %v, expected = %v", setResults[0].Triggered, test.expectedTriggered)
+			}
+		})
+	}
+}
+
+func TestProgramExplain(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{
+			Name:       "formula_1",
+			Expression: "let ratio = s2001 / s6004 in ratio > 0.1 AND s2001 > (s6004 * 0.1)",
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+		{
+			Name:       "formula_2",
+			Expression: "unknown_param > 0",
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+		{
+			Name:       "formula_3",
+			Expression: "@formula_1 AND @score > 1",
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+		{
+			Name:       "score",
+			Expression: "unknown_param * 2",
+			IsEnable:   true,
+			ResultType: core.NUMBER_TYPE,
+		},
+	}
+
+	program, err := Compile(formulas, types, WithExplain())
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	_, formulaRes, err := program.Calculate(paramsWithOneElement)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	expected := `let ratio = s2001 (2000000) / s6004 (10) -> 200000
+(ratio (200000) > 0.1) AND (s2001 (2000000) > (s6004 (10) * 0.1)) -> true
+  ratio (200000) > 0.1 -> true
+  s2001 (2000000) > (s6004 (10) * 0.1) -> true
+    s6004 (10) * 0.1 -> 1`
+	if got := formulaRes[0]["formula_1"].Explanation(); got != expected {
+		t.Errorf("Explanation() got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	expected = "parameter not found: unknown_param -> false"
+	if got := formulaRes[0]["formula_2"].Explanation(); got != expected {
+		t.Errorf("Explanation() got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	expected = "parameter is null: @score -> false"
+	if got := formulaRes[0]["formula_3"].Explanation(); got != expected {
+		t.Errorf("Explanation() got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	expected = "parameter not found: unknown_param -> null"
+	if got := formulaRes[0]["score"].Explanation(); got != expected {
+		t.Errorf("Explanation() got:\n%s\nexpected:\n%s", got, expected)
+	}
+
+	formulas[2].Expression = "@formula_1 AND @formula_2 = false"
+
+	program, err = Compile(formulas, types, WithExplain())
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	_, formulaRes, err = program.Calculate(paramsWithOneElement)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	expected = `@formula_1 (true) AND (@formula_2 (false) = false) -> true
+  @formula_2 (false) = false -> true`
+	if got := formulaRes[0]["formula_3"].Explanation(); got != expected {
+		t.Errorf("Explanation() got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestProgramWorkers(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "let x = s2001 * 2 in x > 0", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 < 0 AND @formula_1 = false", Color: YellowColor, IsEnable: true},
+		{Name: "formula_3", Expression: "s2001 >= 0 AND exists(s6004)", Color: RedColor, IsEnable: true},
+	}
+
+	var rawSets []jparser.RawMessageSet
+	for i := 0; i < 100; i++ {
+		rawSets = append(rawSets, paramsWithMultipleElements...)
+	}
+
+	sequential, err := Compile(formulas, types, WithExplain())
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	parallel, err := Compile(formulas, types, WithExplain(), WithWorkers(8))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	expectedColor, expectedRes, err := sequential.CalculateSets(rawSets)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, setResults, err := parallel.CalculateSets(rawSets)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != expectedColor {
+		t.Errorf("CalculateSets() got resColor = %s, expected = %s", resColor, expectedColor)
+	}
+
+	if !reflect.DeepEqual(setResults, expectedRes) {
+		t.Errorf("CalculateSets() results of workers differ from sequential results")
+	}
+
+	failing, err := Compile([]Formula{{Name: "formula_1", Expression: "bool_param - 1 > 0", Color: RedColor, IsEnable: true}},
+		types, WithWorkers(4))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	if _, _, err = failing.CalculateSets(rawSets); err == nil {
+		t.Errorf("CalculateSets() got error = nil, expected error")
+	}
+}
+
+func TestProgramCalculateContext(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "s2001 > 0", Color: GreenColor, IsEnable: true},
+	}
+
+	for _, workers := range []int{1, 4} {
+		program, err := Compile(formulas, types, WithWorkers(workers))
+		if err != nil {
+			t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, formulaRes, err := program.CalculateContext(ctx, paramsWithMultipleElements)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("CalculateContext() got error = \"%v\", expected context.Canceled", err)
+		}
+
+		if len(formulaRes) != 0 {
+			t.Errorf("CalculateContext() got %d results, expected = 0", len(formulaRes))
+		}
+
+		_, formulaRes, err = program.CalculateContext(context.Background(), paramsWithMultipleElements)
+		if err != nil {
+			t.Errorf("CalculateContext() got error = \"%v\", expected nil", err)
+		}
+
+		if len(formulaRes) != len(paramsWithMultipleElements) {
+			t.Errorf("CalculateContext() got %d results, expected = %d", len(formulaRes), len(paramsWithMultipleElements))
+		}
+	}
+}
+
+func TestProgramCalculateContextPartial(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Cancel the calculation after the first set
+	cancelAfterFirst := AggregatorFunc(func(palette Palette, outcomes []Outcome) (Color, []string) {
+		cancel()
+
+		return WorstOf().Aggregate(palette, outcomes)
+	})
+
+	program, err := Compile([]Formula{{Name: "formula_1", Expression: "s2001 > 0", Color: GreenColor, IsEnable: true}},
+		types, WithAggregator(cancelAfterFirst))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, setResults, err := program.CalculateSetsContext(ctx, paramsWithMultipleElements)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("CalculateSetsContext() got error = \"%v\", expected context.Canceled", err)
+	}
+
+	if len(setResults) != 1 {
+		t.Fatalf("CalculateSetsContext() got %d results, expected = 1", len(setResults))
+	}
+
+	if resColor != GreenColor {
+		t.Errorf("CalculateSetsContext() got resColor = %s, expected = %s", resColor, GreenColor)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		expression string
+		limits     Limits
+		expected   Limit
+	}{
+		{
+			name:       "expression length",
+			expression: "s2001 > 1000000",
+			limits:     Limits{MaxExpressionLength: 10},
+			expected:   ExpressionLengthLimit,
+		},
+		{
+			name:       "number of tokens",
+			expression: "s2001 > 1 AND s2001 > 2",
+			limits:     Limits{MaxTokens: 6},
+			expected:   TokensLimit,
+		},
+		{
+			name:       "nesting depth",
+			expression: "((((s2001)))) > 0",
+			limits:     Limits{MaxDepth: 3},
+			expected:   DepthLimit,
+		},
+		{
+			name:       "function calls",
+			expression: "exists(s2001) AND exists(s6004)",
+			limits:     Limits{MaxFunctionCalls: 1},
+			expected:   FunctionCallsLimit,
+		},
+		{
+			name:       "evaluation steps",
+			expression: "s2001 + s6004 + stated_capital > 0",
+			limits:     Limits{MaxSteps: 4},
+			expected:   StepsLimit,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			formulas := []Formula{{Name: "formula_1", Expression: test.expression, Color: RedColor, IsEnable: true}}
+
+			program, err := Compile(formulas, types, WithLimits(test.limits))
+			if err == nil {
+				_, _, err = program.Calculate(paramsWithOneElement)
+			}
+
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) {
+				t.Fatalf("got error = \"%v\", expected LimitError", err)
+			}
+
+			if limitErr.Limit != test.expected {
+				t.Errorf("got limit = %s, expected = %s", limitErr.Limit, test.expected)
+			}
+
+			program, err = Compile(formulas, types)
+			if err != nil {
+				t.Fatalf("Compile() without limits got error = \"%v\", expected nil", err)
+			}
+
+			if _, _, err = program.Calculate(paramsWithOneElement); err != nil {
+				t.Errorf("Calculate() without limits got error = \"%v\", expected nil", err)
+			}
+		})
+	}
+}
+
+func TestCompileDefaultMaxDepth(t *testing.T) {
+	t.Parallel()
+
+	nested := strings.Repeat("(", DefaultMaxDepth+1) + "s2001" + strings.Repeat(")", DefaultMaxDepth+1) + " > 0"
+	formulas := []Formula{{Name: "formula_1", Expression: nested, Color: RedColor, IsEnable: true}}
+
+	_, err := Compile(formulas, types)
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != DepthLimit {
+		t.Fatalf("Compile() got error = \"%v\", expected LimitError of %s", err, DepthLimit)
+	}
+
+	if _, err = Compile(formulas, types, WithLimits(Limits{MaxDepth: -1})); err != nil {
+		t.Errorf("Compile() without the depth limit got error = \"%v\", expected nil", err)
+	}
+}
+
+func TestProgramLenientTypes(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{
+			Name:       "formula_1",
+			Expression: `revenue > 1000 AND flag AND reg_date < date("2010-01-01") AND okved = "64.19"`,
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+	}
+
+	rawSets := []jparser.RawMessageSet{
+		{
+			"revenue":  json.RawMessage(`"1500"`),
+			"flag":     json.RawMessage(`"true"`),
+			"reg_date": json.RawMessage(`"2008-10-03"`),
+			"okved":    json.RawMessage(`"64.19"`),
+		},
+	}
+
+	if _, _, err := Calculate(formulas, rawSets, nil); err == nil {
+		t.Errorf("Calculate() got error = nil, expected error of different types")
+	}
+
+	program, err := Compile(formulas, nil, WithLenientTypes())
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, _, err := program.Calculate(rawSets)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor {
+		t.Errorf("Calculate() got resColor = %s, expected = %s", resColor, RedColor)
+	}
+
+	// Declared types don't convert strings unless types are lenient
+	paramTypes := map[string]core.ValueType{
+		"revenue":  core.NUMBER_TYPE,
+		"flag":     core.BOOL_TYPE,
+		"reg_date": core.DATE_TYPE,
+		"okved":    core.STRING_TYPE,
+	}
+
+	for _, explain := range []bool{false, true} {
+		opts := []Option{}
+		if explain {
+			opts = append(opts, WithExplain())
+		}
+
+		program, err = Compile(formulas, paramTypes, opts...)
+		if err != nil {
+			t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+		}
+
+		if _, _, err = program.Calculate(rawSets); err == nil || !strings.Contains(err.Error(), "typecast error") {
+			t.Errorf("Calculate() with explain = %t got error = \"%v\", expected typecast error", explain, err)
+		}
+
+		program, err = Compile(formulas, paramTypes, append(opts, WithLenientTypes())...)
+		if err != nil {
+			t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+		}
+
+		if resColor, _, err = program.Calculate(rawSets); err != nil || resColor != RedColor {
+			t.Errorf("Calculate() with explain = %t got resColor = %s, error = \"%v\", expected = %s",
+				explain, resColor, err, RedColor)
+		}
+	}
+}
+
+func TestProgramLocation(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{
+			Name:       "formula_1",
+			Expression: `month(report_date) = 1 AND date("2022-01-01") = date("2021-12-31T21:00:00Z")`,
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+	}
+
+	rawSets := []jparser.RawMessageSet{
+		{"report_date": json.RawMessage(`"2021-12-31T22:30:00Z"`)},
+	}
+
+	paramTypes := map[string]core.ValueType{"report_date": core.DATE_TYPE}
+
+	resColor, _, err := Calculate(formulas, rawSets, paramTypes)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != GreyColor {
+		t.Errorf("Calculate() got resColor = %s, expected = %s", resColor, GreyColor)
+	}
+
+	program, err := Compile(formulas, paramTypes, WithLocation(time.FixedZone("MSK", 3*60*60)))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, _, err = program.Calculate(rawSets)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor {
+		t.Errorf("Calculate() got resColor = %s, expected = %s", resColor, RedColor)
+	}
+
+	program, err = Compile(formulas, paramTypes)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	ctx := ContextWithLocation(context.Background(), time.FixedZone("MSK", 3*60*60))
+
+	resColor, _, err = program.CalculateContext(ctx, rawSets)
+	if err != nil {
+		t.Fatalf("CalculateContext() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor {
+		t.Errorf("CalculateContext() with the location got resColor = %s, expected = %s", resColor, RedColor)
+	}
+
+	resColor, _, err = program.Calculate(rawSets)
+	if err != nil {
+		t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != GreyColor {
+		t.Errorf("Calculate() without the location got resColor = %s, expected = %s", resColor, GreyColor)
+	}
+}
+
+func TestProgramLocationDateOperands(t *testing.T) {
+	t.Parallel()
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("time zone database isn't available: %v", err)
+	}
+
+	formulas := []Formula{
+		{
+			Name:       "formula_1",
+			Expression: `d = date("2021-12-31") AND d < date("2022-01-01") AND string(d) = "2021-12-31T00:00:00+03:00"`,
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+	}
+
+	rawSets := []jparser.RawMessageSet{{"d": json.RawMessage(`"2021-12-31"`)}}
+	paramTypes := map[string]core.ValueType{"d": core.DATE_TYPE}
+
+	for _, explain := range []bool{false, true} {
+		opts := []Option{WithLocation(moscow)}
+		if explain {
+			opts = append(opts, WithExplain())
+		}
+
+		program, err := Compile(formulas, paramTypes, opts...)
+		if err != nil {
+			t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+		}
+
+		resColor, _, err := program.Calculate(rawSets)
+		if err != nil {
+			t.Fatalf("Calculate() got error = \"%v\", expected nil", err)
+		}
+
+		if resColor != RedColor {
+			t.Errorf("Calculate() with explain = %t got resColor = %s, expected = %s", explain, resColor, RedColor)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build race
+
+package calculator
+
+// The race detector makes sync.Pool drop items, so evaluations allocate
+func init() {
+	raceEnabled = true
+}
@@ -0,0 +1,299 @@
+package calculator
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/egelis/calculator/core"
+)
+
+// FormatVersion is the version of the serialized program.
+// It changes whenever a program saved by an older version can't be evaluated the same way.
+const FormatVersion = 1
+
+type FormatVersionError struct {
+	Version int
+}
+
+func (e *FormatVersionError) Error() string {
+	return fmt.Sprintf("unsupported format version of the program: %d, expected %d", e.Version, FormatVersion)
+}
+
+// MalformedProgramError is returned by Load when the saved postfix of a formula can't be evaluated
+type MalformedProgramError struct {
+	Formula string
+	// Step is the position of the token in the postfix starting from 0
+	Step   int
+	Reason string
+}
+
+func (e *MalformedProgramError) Error() string {
+	return fmt.Sprintf("formula '%s' is malformed at step %d: %s", e.Formula, e.Step, e.Reason)
+}
+
+// LocationError is returned by MarshalJSON when the time zone of the program can't be saved
+type LocationError struct {
+	Location string
+}
+
+func (e *LocationError) Error() string {
+	return fmt.Sprintf("time zone can't be saved: %s: it isn't in the IANA database and has no fixed offset",
+		e.Location)
+}
+
+const (
+	errNegativeArgs   = "negative number of arguments"
+	errMissingOperand = "not enough operands"
+	errResultCount    = "expected exactly one result"
+)
+
+type (
+	// serializedProgram is the stable form of Program, formulas are saved parsed
+	serializedProgram struct {
+		FormatVersion int                       `json:"formatVersion"`
+		Formulas      []serializedFormula       `json:"formulas"`
+		ParamTypes    map[string]core.ValueType `json:"paramTypes,omitempty"`
+		Palette       serializedPalette         `json:"palette"`
+		Limits        serializedLimits          `json:"limits"`
+		Lenient       bool                      `json:"lenient,omitempty"`
+		// Location is the name of the time zone in the IANA database, empty for UTC
+		Location string `json:"location,omitempty"`
+		// LocationOffset is set for a fixed zone, it's the offset in seconds east of UTC
+		LocationOffset *int `json:"locationOffset,omitempty"`
+	}
+
+	serializedFormula struct {
+		Name       string            `json:"name"`
+		Version    int64             `json:"version"`
+		Color      Color             `json:"color"`
+		Weight     float64           `json:"weight,omitempty"`
+		ResultType core.ValueType    `json:"resultType"`
+		Postfix    []serializedToken `json:"postfix"`
+	}
+
+	serializedToken struct {
+		Type      core.TokenType `json:"type"`
+		Value     string         `json:"value"`
+		ValueType core.ValueType `json:"valueType,omitempty"`
+		Args      int            `json:"args,omitempty"`
+		Pos       int            `json:"pos"`
+	}
+
+	serializedPalette struct {
+		Levels  []Color `json:"levels"`
+		Default Color   `json:"default"`
+		Error   Color   `json:"error"`
+	}
+
+	serializedLimits struct {
+		MaxExpressionLength int `json:"maxExpressionLength,omitempty"`
+		MaxTokens           int `json:"maxTokens,omitempty"`
+		MaxDepth            int `json:"maxDepth,omitempty"`
+		MaxFunctionCalls    int `json:"maxFunctionCalls,omitempty"`
+		MaxSteps            int `json:"maxSteps,omitempty"`
+	}
+)
+
+// MarshalJSON saves the compiled program, so it can be loaded by Load without parsing formulas.
+// The aggregator, explain and workers options aren't saved, they are passed to Load.
+func (p *Program) MarshalJSON() ([]byte, error) {
+	res := serializedProgram{
+		FormatVersion: FormatVersion,
+		Formulas:      make([]serializedFormula, 0, len(p.declared)),
+		ParamTypes:    p.paramTypes,
+		Palette:       serializedPalette(p.palette),
+		Limits:        serializedLimits(p.limits),
+		Lenient:       p.lenient,
+	}
+
+	if p.location != nil && p.location != time.UTC {
+		res.Location = p.location.String()
+
+		if !loadsByName(p.location) {
+			offset, ok := fixedOffset(p.location)
+			if !ok {
+				return nil, &LocationError{Location: res.Location}
+			}
+
+			res.LocationOffset = &offset
+		}
+	}
+
+	for _, formula := range p.declared {
+		postfix := make([]serializedToken, 0, len(formula.Postfix))
+		for _, token := range formula.Postfix {
+			postfix = append(postfix, serializedToken(token))
+		}
+
+		res.Formulas = append(res.Formulas, serializedFormula{
+			Name:       formula.Name,
+			Version:    formula.Version,
+			Color:      formula.Color,
+			Weight:     formula.Weight,
+			ResultType: formula.ResultType,
+			Postfix:    postfix,
+		})
+	}
+
+	return json.Marshal(res)
+}
+
+// Load loads the program saved by Program.MarshalJSON.
+// Options are applied after saved settings, e.g. WithLocation replaces the saved time zone.
+// Formulas aren't parsed again, but types, regular expressions and references are checked like in Compile.
+func Load(data []byte, opts ...Option) (*Program, error) {
+	var saved serializedProgram
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	if saved.FormatVersion != FormatVersion {
+		return nil, &FormatVersionError{Version: saved.FormatVersion}
+	}
+
+	program := &Program{
+		palette:    Palette(saved.Palette),
+		aggregator: WorstOf(),
+		limits:     Limits(saved.Limits),
+		lenient:    saved.Lenient,
+		paramTypes: saved.ParamTypes,
+	}
+
+	for _, opt := range opts {
+		opt(program)
+	}
+
+	if program.location == nil && saved.LocationOffset != nil {
+		program.location = time.FixedZone(saved.Location, *saved.LocationOffset)
+	} else if program.location == nil && saved.Location != "" {
+		location, err := time.LoadLocation(saved.Location)
+		if err != nil {
+			return nil, err
+		}
+
+		program.location = location
+	}
+
+	precedence, err := program.palette.precedence()
+	if err != nil {
+		return nil, err
+	}
+
+	program.precedence = precedence
+
+	if err := checkAggregatorColors(program.aggregator, precedence); err != nil {
+		return nil, err
+	}
+
+	formulas := make([]tokenizedFormula, 0, len(saved.Formulas))
+
+	for _, formula := range saved.Formulas {
+		postfix := make([]core.Token, 0, len(formula.Postfix))
+		for _, token := range formula.Postfix {
+			postfix = append(postfix, core.Token(token))
+		}
+
+		loaded := tokenizedFormula{
+			Postfix:    postfix,
+			Refs:       formulaRefs(postfix),
+			Name:       formula.Name,
+			Version:    formula.Version,
+			Color:      formula.Color,
+			Weight:     formula.Weight,
+			ResultType: formula.ResultType,
+		}
+
+		if err := checkColor(precedence, loaded); err != nil {
+			return nil, err
+		}
+
+		if err := checkSavedPostfix(loaded.Name, loaded.Postfix); err != nil {
+			return nil, err
+		}
+
+		if err := compilePostfix(&loaded, program.limits); err != nil {
+			return nil, err
+		}
+
+		formulas = append(formulas, loaded)
+	}
+
+	if err := program.compileBytecode(formulas); err != nil {
+		return nil, err
+	}
+
+	orderedFormulas, err := orderFormulas(formulas)
+	if err != nil {
+		return nil, err
+	}
+
+	program.formulas = orderedFormulas
+	program.declared = formulas
+
+	return program, nil
+}
+
+// checkSavedPostfix checks that each operator and function has its operands on the stack
+// and exactly one result remains, so a malformed program fails Load instead of the calculation
+func checkSavedPostfix(name string, postfix []core.Token) error {
+	depth := 0
+
+	for i, token := range postfix {
+		pops, pushes := 0, 1
+
+		switch token.Type {
+		case core.LOG_OP, core.COMP_OP, core.ARITH_OP:
+			pops = 2
+		case core.FUNC:
+			if token.Args < 0 {
+				return &MalformedProgramError{Formula: name, Step: i, Reason: errNegativeArgs}
+			}
+
+			pops = token.Args
+		case core.ASSIGN:
+			pops, pushes = 1, 0
+		}
+
+		if depth < pops {
+			return &MalformedProgramError{Formula: name, Step: i, Reason: errMissingOperand}
+		}
+
+		depth += pushes - pops
+	}
+
+	if depth != 1 {
+		return &MalformedProgramError{Formula: name, Step: len(postfix), Reason: errResultCount}
+	}
+
+	return nil
+}
+
+// loadsByName reports whether Load gets the same time zone by its name
+func loadsByName(location *time.Location) bool {
+	loaded, err := time.LoadLocation(location.String())
+	if err != nil {
+		return false
+	}
+
+	for _, month := range []time.Month{time.January, time.July} {
+		at := time.Date(time.Now().Year(), month, 1, 0, 0, 0, 0, time.UTC)
+
+		_, expected := at.In(location).Zone()
+		if _, actual := at.In(loaded).Zone(); actual != expected {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fixedOffset returns the offset of the time zone if it's the same in winter and summer
+func fixedOffset(location *time.Location) (int, bool) {
+	year := time.Now().Year()
+
+	_, winter := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).In(location).Zone()
+	_, summer := time.Date(year, time.July, 1, 0, 0, 0, 0, time.UTC).In(location).Zone()
+
+	return winter, winter == summer
+}
@@ -0,0 +1,239 @@
+package calculator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/egelis/calculator/core"
+)
+
+func TestProgramSerialization(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "score", Expression: "let x = s2001 * 2 in x + 1", Color: "low", IsEnable: true, Weight: 2,
+			ResultType: core.NUMBER_TYPE},
+		{Name: "formula_1", Expression: "@score > 0 AND exists(s6004)", Color: "high", Version: 3, IsEnable: true},
+		{Name: "formula_2", Expression: `string(s2001) matches "^-"`, Color: "critical", IsEnable: true},
+		{Name: "formula_3", Expression: "s2001 = 0", Color: "medium", IsEnable: false},
+	}
+
+	program, err := Compile(formulas, types, WithPalette(severityPalette), WithLocation(time.UTC))
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	data, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("Marshal() got error = \"%v\", expected nil", err)
+	}
+
+	loaded, err := Load(data, WithExplain())
+	if err != nil {
+		t.Fatalf("Load() got error = \"%v\", expected nil", err)
+	}
+
+	expectedColor, expectedRes, err := program.CalculateSets(paramsWithMultipleElements)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	resColor, setResults, err := loaded.CalculateSets(paramsWithMultipleElements)
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != expectedColor {
+		t.Errorf("CalculateSets() got resColor = %s, expected = %s", resColor, expectedColor)
+	}
+
+	for i := range setResults {
+		for name, value := range setResults[i].Formulas {
+			if value.Trace == nil {
+				t.Errorf("CalculateSets() got no trace of %s, expected explained results", name)
+			}
+
+			value.Trace = nil
+			setResults[i].Formulas[name] = value
+		}
+	}
+
+	if !reflect.DeepEqual(setResults, expectedRes) {
+		t.Errorf("CalculateSets() got %v, expected = %v", setResults, expectedRes)
+	}
+
+	again, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("Marshal() got error = \"%v\", expected nil", err)
+	}
+
+	if !bytes.Equal(again, data) {
+		t.Errorf("Marshal() of the loaded program got %s, expected = %s", again, data)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		data  string
+		check func(err error) bool
+	}{
+		{
+			name: "format version",
+			data: `{"formatVersion": 0}`,
+			check: func(err error) bool {
+				var versionErr *FormatVersionError
+
+				return errors.As(err, &versionErr)
+			},
+		},
+		{
+			name: "unknown color",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "red", "resultType": "bool",
+				"postfix": [{"type": "boolWord", "value": "true", "valueType": "bool"}]}]}`,
+			check: func(err error) bool {
+				var colorErr *UnknownColorError
+
+				return errors.As(err, &colorErr)
+			},
+		},
+		{
+			name: "result type",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool",
+				"postfix": [{"type": "number", "value": "1", "valueType": "number"}]}]}`,
+			check: func(err error) bool {
+				var typeErr *ResultTypeError
+
+				return errors.As(err, &typeErr)
+			},
+		},
+		{
+			name: "unknown formula",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool",
+				"postfix": [{"type": "formulaRef", "value": "formula_2", "valueType": "bool"}]}]}`,
+			check: func(err error) bool {
+				var formulaErr *UnknownFormulaError
+
+				return errors.As(err, &formulaErr)
+			},
+		},
+		{
+			name: "negative arguments",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool",
+				"postfix": [{"type": "function", "value": "exists", "args": -1}]}]}`,
+			check: isMalformed,
+		},
+		{
+			name: "missing operand",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool",
+				"postfix": [{"type": "boolWord", "value": "true", "valueType": "bool"},
+				{"type": "logicOp", "value": "AND"}]}]}`,
+			check: isMalformed,
+		},
+		{
+			name: "unbalanced stack",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool",
+				"postfix": [{"type": "boolWord", "value": "true", "valueType": "bool"},
+				{"type": "boolWord", "value": "true", "valueType": "bool"}]}]}`,
+			check: isMalformed,
+		},
+		{
+			name: "empty postfix",
+			data: `{"formatVersion": 1, "palette": {"levels": ["low"], "default": "low", "error": "low"},
+				"formulas": [{"name": "formula_1", "color": "low", "resultType": "bool", "postfix": []}]}`,
+			check: isMalformed,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Load([]byte(test.data)); !test.check(err) {
+				t.Errorf("Load() got error = \"%v\"", err)
+			}
+		})
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{{Name: "formula_1", Expression: "(exists(s2001) AND exists(s6004))", Color: RedColor, IsEnable: true}}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	data, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("Marshal() got error = \"%v\", expected nil", err)
+	}
+
+	// Limits of the source of formulas aren't checked, formulas aren't parsed again
+	if _, err = Load(data, WithLimits(Limits{MaxDepth: 1, MaxTokens: 2, MaxExpressionLength: 1})); err != nil {
+		t.Errorf("Load() with source limits got error = \"%v\", expected nil", err)
+	}
+
+	for limit, limits := range map[Limit]Limits{
+		FunctionCallsLimit: {MaxFunctionCalls: 1},
+		StepsLimit:         {MaxSteps: 4},
+	} {
+		_, err = Load(data, WithLimits(limits))
+
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+			t.Errorf("Load() got error = \"%v\", expected LimitError of %s", err, limit)
+		}
+	}
+}
+
+func isMalformed(err error) bool {
+	var malformedErr *MalformedProgramError
+
+	return errors.As(err, &malformedErr)
+}
+
+func TestProgramSerializationFixedZone(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{{Name: "formula_1", Expression: "year(today()) > 2000", Color: RedColor, IsEnable: true}}
+
+	// EST is also the name of a zone in the IANA database, but with a different offset
+	for _, location := range []*time.Location{time.FixedZone("MSK", 3*3600), time.FixedZone("EST", 3*3600)} {
+		program, err := Compile(formulas, types, WithLocation(location))
+		if err != nil {
+			t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+		}
+
+		data, err := json.Marshal(program)
+		if err != nil {
+			t.Fatalf("Marshal() got error = \"%v\", expected nil", err)
+		}
+
+		loaded, err := Load(data)
+		if err != nil {
+			t.Fatalf("Load() got error = \"%v\", expected nil", err)
+		}
+
+		name, offset := time.Now().In(loaded.location).Zone()
+		if name != location.String() || offset != 3*3600 {
+			t.Errorf("Load() got time zone %s%+d, expected %s+10800", name, offset, location)
+		}
+	}
+}
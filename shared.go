@@ -0,0 +1,132 @@
+package calculator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/egelis/calculator/core"
+)
+
+// sharedExpression is a subexpression of several formulas, it is evaluated once per set of parameters
+type sharedExpression struct {
+	Name     string
+	Tokens   []core.Token
+	Bytecode *core.Bytecode
+}
+
+// compileBytecode compiles the bytecode of optimized formulas and keeps their patterns.
+// Subexpressions which occur more than once are compiled separately and replaced by their names.
+func (p *Program) compileBytecode(formulas []tokenizedFormula) error {
+	exps := make([][]core.Token, 0, len(formulas))
+	p.patterns = core.Patterns{}
+
+	for _, formula := range formulas {
+		exps = append(exps, formula.Optimized)
+
+		// Shared subexpressions may use patterns of any formula
+		for pattern, re := range formula.Patterns {
+			p.patterns[pattern] = re
+		}
+	}
+
+	shared, exps := core.ShareSubexpressions(exps)
+
+	p.shared = make([]sharedExpression, 0, len(shared))
+	p.sharedIndex = make(map[string]int, len(shared))
+
+	for _, expression := range shared {
+		bytecode, err := core.CompileBytecode(expression.Tokens)
+		if err != nil {
+			return &TypeCheckError{Formula: expression.Name, Err: err}
+		}
+
+		p.sharedIndex[expression.Name] = len(p.shared)
+		p.shared = append(p.shared, sharedExpression{Name: expression.Name, Tokens: expression.Tokens, Bytecode: bytecode})
+	}
+
+	for i, exp := range exps {
+		bytecode, err := core.CompileBytecode(exp)
+		if err != nil {
+			return &TypeCheckError{Formula: formulas[i].Name, Err: err}
+		}
+
+		formulas[i].Optimized = exp
+		formulas[i].Bytecode = bytecode
+	}
+
+	return nil
+}
+
+// Dump renders shared subexpressions and formulas as they are evaluated, e.g.
+//
+//	$1 = s2001 / s6004
+//	formula_1 = ($1 > 2) AND exists(founder_url)
+//	formula_2 = $1 < 0.5
+//
+// Programs compiled WithExplain evaluate parsed formulas without optimizations, so they are rendered as parsed.
+func (p *Program) Dump() string {
+	lines := make([]string, 0, len(p.shared)+len(p.declared))
+
+	if p.explain {
+		for _, formula := range p.declared {
+			lines = append(lines, fmt.Sprintf("%s = %s", formula.Name, core.FormatPostfix(formula.Postfix)))
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	for _, expression := range p.shared {
+		lines = append(lines, fmt.Sprintf("%s = %s", expression.Name, core.FormatPostfix(expression.Tokens)))
+	}
+
+	for _, formula := range p.declared {
+		lines = append(lines, fmt.Sprintf("%s = %s", formula.Name, core.FormatPostfix(formula.Optimized)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// setValues keeps values of shared subexpressions for one set of parameters
+type setValues struct {
+	ctx     context.Context // nolint:containedctx
+	program *Program
+	source  core.ParamSource
+	results FormulaResult
+	shared  map[string]core.Token
+}
+
+func (p *Program) newSetValues(ctx context.Context, source core.ParamSource, results FormulaResult) *setValues {
+	return &setValues{
+		ctx:     ctx,
+		program: p,
+		source:  source,
+		results: results,
+		shared:  make(map[string]core.Token, len(p.shared)),
+	}
+}
+
+// resolve is the core.RefResolver of formulas of the set, a shared subexpression is evaluated on the first use
+func (s *setValues) resolve(ref core.Token) (core.Token, error) {
+	if ref.Type != core.SHARED {
+		return s.results.resolveRef(ref)
+	}
+
+	if value, ok := s.shared[ref.Value]; ok {
+		return value, nil
+	}
+
+	i, ok := s.program.sharedIndex[ref.Value]
+	if !ok {
+		return core.Token{}, &UnknownFormulaError{Ref: ref.Value}
+	}
+
+	value, err := s.program.shared[i].Bytecode.EvaluateSubexpression(s.ctx, s.source, s.resolve, s.program.options(s.ctx))
+	if err != nil {
+		return core.Token{}, err
+	}
+
+	s.shared[ref.Value] = value
+
+	return value, nil
+}
@@ -0,0 +1,111 @@
+// nolint:revive
+package calculator
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/egelis/calculator/core"
+)
+
+type company struct {
+	Revenue   float64 `calc:"s2001"`
+	Employees int     `calc:"s6004"`
+	Capital   *int64  `calc:"stated_capital"`
+	Active    bool    `calc:"bool_param"`
+	Internal  string  `calc:"-"`
+}
+
+func TestProgramCalculateSources(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "s2001 > (s6004 * 0.1) AND bool_param = false", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "exists(stated_capital) AND stated_capital > 10", Color: RedColor, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	capital := int64(50)
+	fetched := map[string]int{}
+
+	sources := []core.ParamSource{
+		core.RawSource(paramsWithOneElement[0]),
+		core.RawSource(map[string]json.RawMessage{"s2001": json.RawMessage("2000000"), "s6004": json.RawMessage("10"),
+			"stated_capital": json.RawMessage("50"), "bool_param": json.RawMessage("false")}),
+		core.MapSource(map[string]any{"s2001": 2000000, "s6004": 10.0, "stated_capital": int64(50), "bool_param": false}),
+		core.StructSource(&company{Revenue: 2000000, Employees: 10, Capital: &capital}),
+		core.LazySource(func(name string) (any, bool, error) {
+			fetched[name]++
+
+			value, ok := map[string]any{"s2001": 2000000, "s6004": 10, "stated_capital": 50, "bool_param": false}[name]
+
+			return value, ok, nil
+		}),
+	}
+
+	_, setResults, err := program.CalculateSources(sources)
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	for i, setResult := range setResults {
+		if !setResult.Formulas["formula_1"].Result || !setResult.Formulas["formula_2"].Result {
+			t.Errorf("CalculateSources() got results[%d] = %v, expected true formulas", i, setResult.Formulas)
+		}
+	}
+
+	if !reflect.DeepEqual(fetched, map[string]int{"s2001": 1, "s6004": 1, "stated_capital": 1, "bool_param": 1}) {
+		t.Errorf("LazySource fetched parameters %v, expected once each", fetched)
+	}
+
+	_, setResults, err = program.CalculateSources([]core.ParamSource{core.StructSource(company{Revenue: 2000000})})
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	if setResults[0].Formulas["formula_2"].Result {
+		t.Errorf("CalculateSources() got true formula with nil pointer field, expected false")
+	}
+
+	errFetch := errors.New("fetch failed")
+
+	_, _, err = program.CalculateSources([]core.ParamSource{
+		core.LazySource(func(name string) (any, bool, error) {
+			return nil, false, errFetch
+		}),
+	})
+	if !errors.Is(err, errFetch) {
+		t.Errorf("CalculateSources() got error = \"%v\", expected = \"%v\"", err, errFetch)
+	}
+}
+
+func TestRawSourceValues(t *testing.T) {
+	t.Parallel()
+
+	for _, raw := range []string{
+		`null`, `true`, ` false `, `12.50`, `-1e3`, `"ok"`, `"ООО \"Ромашка\""`, "\"\xff\"", `""`,
+		`[1, "a"]`, `{"inn": "7707083893"}`, `1 2`,
+	} {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+
+		var expected any
+		expectedErr := decoder.Decode(&expected)
+
+		value, ok, err := core.RawSource(map[string]json.RawMessage{"param": json.RawMessage(raw)}).Lookup("param")
+		if (err != nil) != (expectedErr != nil) || ok != (expectedErr == nil) {
+			t.Errorf("Lookup(%s) got ok = %t, error = \"%v\", expected error = \"%v\"", raw, ok, err, expectedErr)
+		}
+
+		if !reflect.DeepEqual(value, expected) {
+			t.Errorf("Lookup(%s) got %#v, expected = %#v", raw, value, expected)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package calculator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/egelis/calculator/core"
+	"github.com/egelis/jparser"
+)
+
+// SetIterator provides sets of parameters one by one.
+// Next returns io.EOF when there are no more sets.
+type SetIterator interface {
+	Next() (jparser.RawMessageSet, error)
+}
+
+// SetIteratorFunc allows to use an ordinary function as a SetIterator
+type SetIteratorFunc func() (jparser.RawMessageSet, error)
+
+func (f SetIteratorFunc) Next() (jparser.RawMessageSet, error) {
+	return f()
+}
+
+// SourceIterator provides sources of parameters one by one.
+// Next returns io.EOF when there are no more sources.
+type SourceIterator interface {
+	Next() (core.ParamSource, error)
+}
+
+// SourceIteratorFunc allows to use an ordinary function as a SourceIterator
+type SourceIteratorFunc func() (core.ParamSource, error)
+
+func (f SourceIteratorFunc) Next() (core.ParamSource, error) {
+	return f()
+}
+
+// ChannelIterator provides sets from the channel until it is closed.
+// Next returns ctx.Err() if the context is done while it waits for a set.
+func ChannelIterator(ctx context.Context, sets <-chan jparser.RawMessageSet) SetIterator {
+	return SetIteratorFunc(func() (jparser.RawMessageSet, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case set, ok := <-sets:
+			if !ok {
+				return nil, io.EOF
+			}
+
+			return set, nil
+		}
+	})
+}
+
+// JSONLinesIterator reads JSON documents line by line and extracts sets of parameters
+// from each of them by jparser.ParseParams, so one line can produce several sets.
+// Empty lines are skipped.
+func JSONLinesIterator(r io.Reader, meta []jparser.MetaData) SetIterator {
+	return &jsonLinesIterator{
+		reader: bufio.NewReader(r),
+		meta:   meta,
+	}
+}
+
+type jsonLinesIterator struct {
+	reader  *bufio.Reader
+	meta    []jparser.MetaData
+	pending []jparser.RawMessageSet
+}
+
+func (it *jsonLinesIterator) Next() (jparser.RawMessageSet, error) {
+	for len(it.pending) == 0 {
+		line, err := it.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			sets, parseErr := jparser.ParseParams(json.RawMessage(line), it.meta)
+			if parseErr != nil {
+				return nil, parseErr
+			}
+
+			it.pending = sets
+		}
+
+		if err != nil && len(it.pending) == 0 {
+			return nil, err
+		}
+	}
+
+	set := it.pending[0]
+	it.pending = it.pending[1:]
+
+	return set, nil
+}
+
+// Stream calculates sets from 'sets' one by one and passes each result to 'emit',
+// so the memory doesn't depend on the number of sets. Sets are calculated sequentially.
+// It returns the worst-case color of all emitted results.
+// The calculation stops on the first error of the iterator, the calculation, 'emit' or the context.
+func (p *Program) Stream(ctx context.Context, sets SetIterator, emit func(SetResult) error) (Color, error) {
+	sources := SourceIteratorFunc(func() (core.ParamSource, error) {
+		rawSet, err := sets.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		return core.RawSource(rawSet), nil
+	})
+
+	return p.StreamSources(ctx, sources, emit)
+}
+
+// StreamSources calculates formulas like Stream taking parameters from 'sources'
+func (p *Program) StreamSources(ctx context.Context, sources SourceIterator, emit func(SetResult) error,
+) (Color, error) {
+	resColor := p.palette.Default
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return resColor, err
+		}
+
+		source, err := sources.Next()
+		if errors.Is(err, io.EOF) {
+			return resColor, nil
+		}
+
+		if isContextError(err) {
+			return resColor, err
+		}
+
+		if err != nil {
+			return p.palette.Error, err
+		}
+
+		setResult, err := p.calculateSet(ctx, source)
+		if isContextError(err) {
+			return resColor, err
+		}
+
+		if err != nil {
+			return p.palette.Error, err
+		}
+
+		if len(setResult.Formulas) == 0 {
+			continue
+		}
+
+		if p.precedence[setResult.Color] > p.precedence[resColor] {
+			resColor = setResult.Color
+		}
+
+		if err = emit(setResult); err != nil {
+			return resColor, err
+		}
+	}
+}
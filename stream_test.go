@@ -0,0 +1,118 @@
+// nolint:revive
+package calculator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/egelis/jparser"
+)
+
+func TestProgramStream(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "bool_param = false", Color: GreenColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 < 0", Color: YellowColor, Version: 1, IsEnable: true},
+		{Name: "formula_3", Expression: "s2001 >= 0 AND exists(s6004)", Color: RedColor, Version: 2, IsEnable: true},
+	}
+
+	program, err := Compile(formulas, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	expectedColor, expectedRes, err := program.CalculateSets(append(paramsWithOneElement, paramsWithMultipleElements...))
+	if err != nil {
+		t.Fatalf("CalculateSets() got error = \"%v\", expected nil", err)
+	}
+
+	input := strings.NewReader(`{"s2001": 2000000, "s6004": 10, "stated_capital": 50, "bool_param": false}
+
+{"stated_capital": 50, "bool_param": false, "x": [{"s2001": 10}, {"s2001": 0, "s6004": 10}, {"s2001": -10, "s6004": 10}]}`)
+
+	meta := []jparser.MetaData{
+		{Path: "s2001", ParamID: "s2001"},
+		{Path: "s6004", ParamID: "s6004"},
+		{Path: "x.[].s2001", ParamID: "s2001"},
+		{Path: "x.[].s6004", ParamID: "s6004"},
+		{Path: "stated_capital", ParamID: "stated_capital"},
+		{Path: "bool_param", ParamID: "bool_param"},
+	}
+
+	var setResults []SetResult
+
+	resColor, err := program.Stream(context.Background(), JSONLinesIterator(input, meta), func(res SetResult) error {
+		setResults = append(setResults, res)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != expectedColor {
+		t.Errorf("Stream() got resColor = %s, expected = %s", resColor, expectedColor)
+	}
+
+	if !reflect.DeepEqual(setResults, expectedRes) {
+		t.Errorf("Stream() got results = %v, expected = %v", setResults, expectedRes)
+	}
+
+	sets := make(chan jparser.RawMessageSet, len(paramsWithMultipleElements))
+	for _, set := range paramsWithMultipleElements {
+		sets <- set
+	}
+	close(sets)
+
+	count := 0
+
+	resColor, err = program.Stream(context.Background(), ChannelIterator(context.Background(), sets), func(SetResult) error {
+		count++
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor || count != len(paramsWithMultipleElements) {
+		t.Errorf("Stream() got resColor = %s and %d results, expected = %s and %d",
+			resColor, count, RedColor, len(paramsWithMultipleElements))
+	}
+}
+
+func TestChannelIteratorContext(t *testing.T) {
+	t.Parallel()
+
+	program, err := Compile([]Formula{{Name: "formula_1", Expression: "s2001 > 0", Color: RedColor, IsEnable: true}}, types)
+	if err != nil {
+		t.Fatalf("Compile() got error = \"%v\", expected nil", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// The producer stalls after the first set
+	sets := make(chan jparser.RawMessageSet, 1)
+	sets <- paramsWithOneElement[0]
+
+	count := 0
+
+	resColor, err := program.Stream(ctx, ChannelIterator(ctx, sets), func(SetResult) error {
+		count++
+
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Stream() got error = \"%v\", expected context.DeadlineExceeded", err)
+	}
+
+	if resColor != RedColor || count != 1 {
+		t.Errorf("Stream() got resColor = %s and %d results, expected = %s and 1", resColor, count, RedColor)
+	}
+}
@@ -0,0 +1,165 @@
+package calculator
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/egelis/calculator/core"
+)
+
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("parameters must be a struct, got: %s", e.Type)
+}
+
+type UndefinedParamError struct {
+	Formula string
+	Param   string
+}
+
+func (e *UndefinedParamError) Error() string {
+	return fmt.Sprintf("formula '%s' references undefined parameter: %s", e.Formula, e.Param)
+}
+
+// CompileStruct compiles formulas for parameters of the struct type of 'sample' (a struct or a pointer to it).
+// Parameters are fields named by `calc:"name"` tags (see core.StructSource), their types are derived from
+// Go types of fields, and formulas referencing other parameters are rejected.
+func CompileStruct(formulas []Formula, sample any, opts ...Option) (*Program, error) {
+	paramTypes, err := ParamTypesOf(reflect.TypeOf(sample))
+	if err != nil {
+		return nil, err
+	}
+
+	return Compile(formulas, paramTypes, append(opts, withStrictParams())...)
+}
+
+// ParamTypesOf returns types of parameters provided by core.StructSource for values of the struct type 't'.
+// Fields of nested structs are named by dotted paths, fields of unsupported types are skipped.
+func ParamTypesOf(t reflect.Type) (map[string]core.ValueType, error) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, &UnsupportedTypeError{Type: t}
+	}
+
+	res := map[string]core.ValueType{}
+	collectParamTypes(t, "", res, map[reflect.Type]bool{})
+
+	return res, nil
+}
+
+func collectParamTypes(t reflect.Type, prefix string, res map[string]core.ValueType, visiting map[reflect.Type]bool) {
+	// Recursive types can't be flattened
+	if visiting[t] {
+		return
+	}
+
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for name, field := range core.StructFields(t) {
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		switch fieldType.Kind() { // nolint:exhaustive
+		case reflect.Bool:
+			res[prefix+name] = core.BOOL_TYPE
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			res[prefix+name] = core.NUMBER_TYPE
+		case reflect.String:
+			res[prefix+name] = core.STRING_TYPE
+		case reflect.Slice, reflect.Array:
+			res[prefix+name] = core.ARRAY_TYPE
+		case reflect.Struct:
+			if fieldType == timeType {
+				res[prefix+name] = core.DATE_TYPE
+
+				continue
+			}
+
+			collectParamTypes(fieldType, prefix+name+".", res, visiting)
+		}
+	}
+}
+
+// nolint:gochecknoglobals
+var timeType = reflect.TypeOf(time.Time{})
+
+// StructSources wraps each value by core.StructSource
+func StructSources[T any](values []T) []core.ParamSource {
+	res := make([]core.ParamSource, 0, len(values))
+	for _, value := range values {
+		res = append(res, core.StructSource(value))
+	}
+
+	return res
+}
+
+// withStrictParams rejects formulas referencing parameters missing from paramTypes
+func withStrictParams() Option {
+	return func(p *Program) {
+		p.strictParams = true
+	}
+}
+
+// checkParams returns an error if the formula references a parameter of unknown type.
+// Names of local variables are not parameters.
+func checkParams(formula tokenizedFormula) error {
+	locals := localNames(formula.Tokens)
+
+	for _, token := range formula.Tokens {
+		if token.Type != core.IDENT || token.ValueType != core.UNKNOWN_TYPE {
+			continue
+		}
+
+		if _, ok := locals[token.Value]; !ok {
+			return &UndefinedParamError{Formula: formula.Name, Param: token.Value}
+		}
+	}
+
+	return nil
+}
+
+// localNames returns names bound in the 'let' block: identifiers followed by '=' directly after 'let' or ','
+func localNames(tokens []core.Token) map[string]struct{} {
+	res := map[string]struct{}{}
+
+	var (
+		inLet bool
+		depth int
+	)
+
+	for i, token := range tokens {
+		switch token.Type { // nolint:exhaustive
+		case core.LET:
+			inLet = true
+		case core.IN:
+			inLet = false
+		case core.LBR:
+			depth++
+		case core.RBR:
+			depth--
+		case core.IDENT:
+			if !inLet || depth != 0 || i == 0 || i+1 == len(tokens) {
+				continue
+			}
+
+			prev, next := tokens[i-1], tokens[i+1]
+			if (prev.Type == core.LET || prev.Type == core.COMMA) && next.Type == core.COMP_OP && next.Value == "=" {
+				res[token.Value] = struct{}{}
+			}
+		}
+	}
+
+	return res
+}
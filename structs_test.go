@@ -0,0 +1,126 @@
+// nolint:revive
+package calculator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/egelis/calculator/core"
+)
+
+type founder struct {
+	Share float64 `calc:"share"`
+}
+
+type holding struct {
+	company
+	Founder founder  `calc:"founder"`
+	Parent  *holding `calc:"parent"`
+	Tags    []int
+}
+
+func TestParamTypesOf(t *testing.T) {
+	t.Parallel()
+
+	res, err := ParamTypesOf(reflect.TypeOf(&holding{}))
+	if err != nil {
+		t.Fatalf("ParamTypesOf() got error = \"%v\", expected nil", err)
+	}
+
+	expected := map[string]core.ValueType{
+		"s2001":          core.NUMBER_TYPE,
+		"s6004":          core.NUMBER_TYPE,
+		"stated_capital": core.NUMBER_TYPE,
+		"bool_param":     core.BOOL_TYPE,
+		"founder.share":  core.NUMBER_TYPE,
+		"Tags":           core.ARRAY_TYPE,
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("ParamTypesOf() got = %v, expected = %v", res, expected)
+	}
+
+	res, err = ParamTypesOf(reflect.TypeOf(company{}))
+	if err != nil {
+		t.Fatalf("ParamTypesOf() got error = \"%v\", expected nil", err)
+	}
+
+	if !reflect.DeepEqual(res, types) {
+		t.Errorf("ParamTypesOf() got = %v, expected = %v", res, types)
+	}
+
+	if _, err = ParamTypesOf(reflect.TypeOf(1)); err == nil {
+		t.Errorf("ParamTypesOf() got error = nil, expected error")
+	}
+}
+
+func TestCompileStruct(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{
+			Name:       "formula_1",
+			Expression: "let ratio = s2001 / s6004 in ratio > 1000 AND bool_param = false",
+			Color:      RedColor,
+			IsEnable:   true,
+		},
+	}
+
+	program, err := CompileStruct(formulas, company{})
+	if err != nil {
+		t.Fatalf("CompileStruct() got error = \"%v\", expected nil", err)
+	}
+
+	capital := int64(50)
+
+	resColor, setResults, err := program.CalculateSources(StructSources([]company{
+		{Revenue: 2000000, Employees: 10, Capital: &capital},
+		{Revenue: 2000, Employees: 10},
+	}))
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	if resColor != RedColor || !setResults[0].Formulas["formula_1"].Result || setResults[1].Formulas["formula_1"].Result {
+		t.Errorf("CalculateSources() got color = %s, results = %v", resColor, setResults)
+	}
+
+	formulas[0].Expression = "s2001 > 0 AND unknown_param > 0"
+
+	_, err = CompileStruct(formulas, &company{})
+
+	var undefinedErr *UndefinedParamError
+	if !errors.As(err, &undefinedErr) || undefinedErr.Param != "unknown_param" {
+		t.Errorf("CompileStruct() got error = \"%v\", expected UndefinedParamError", err)
+	}
+}
+
+func TestCompileStructNestedFields(t *testing.T) {
+	t.Parallel()
+
+	formulas := []Formula{
+		{Name: "formula_1", Expression: "founder.share > 0.5", Color: RedColor, IsEnable: true},
+		{Name: "formula_2", Expression: "s2001 > 1500", Color: RedColor, IsEnable: true},
+	}
+
+	program, err := CompileStruct(formulas, holding{})
+	if err != nil {
+		t.Fatalf("CompileStruct() got error = \"%v\", expected nil", err)
+	}
+
+	_, setResults, err := program.CalculateSources(StructSources([]holding{
+		{Founder: founder{Share: 0.6}, company: company{Revenue: 2000}},
+		{Founder: founder{Share: 0.4}, company: company{Revenue: 1000}},
+	}))
+	if err != nil {
+		t.Fatalf("CalculateSources() got error = \"%v\", expected nil", err)
+	}
+
+	if !setResults[0].Formulas["formula_1"].Result || setResults[1].Formulas["formula_1"].Result {
+		t.Errorf("CalculateSources() got results = %v, expected true and false", setResults)
+	}
+
+	if !setResults[0].Formulas["formula_2"].Result || setResults[1].Formulas["formula_2"].Result {
+		t.Errorf("CalculateSources() got results = %v, expected true and false for promoted fields", setResults)
+	}
+}
@@ -17,6 +17,15 @@ func (e *InvalidTokenError) Error() string {
 	return fmt.Sprintf("invalid token at position: %d", e.Position)
 }
 
+type UnknownFunctionError struct {
+	Name     string
+	Position int
+}
+
+func (e *UnknownFunctionError) Error() string {
+	return fmt.Sprintf("unknown function '%s' at position: %d", e.Name, e.Position)
+}
+
 func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token, error) {
 	chars := []rune(input)
 	inputLen := len(chars)
@@ -33,8 +42,10 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 		if isAlpha(char) {
 			start := i
 
+			// A path of nested parameters is one identifier, e.g. company.founder.inn
 			i++
-			for i < inputLen && (isAlpha(chars[i]) || isDigit(chars[i]) || chars[i] == '_') {
+			for i < inputLen && (isAlpha(chars[i]) || isDigit(chars[i]) || chars[i] == '_' ||
+				(chars[i] == '.' && i+1 < inputLen && isAlpha(chars[i+1]))) {
 				i++
 			}
 
@@ -48,8 +59,18 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 				valueType = core.BOOL_TYPE
 			case isOrAnd(chars[start:i]):
 				tokenType = core.LOG_OP
-			case isExistsFunc(chars[start:i]):
-				tokenType = core.EXISTS_FUNC
+			case isMatchesOp(chars[start:i]):
+				tokenType = core.COMP_OP
+			case isLetKeyword(chars[start:i]):
+				tokenType = core.LET
+			case isInKeyword(chars[start:i]):
+				tokenType = core.IN
+			case isFuncCall(chars, i, inputLen):
+				if !core.IsFunction(string(chars[start:i])) {
+					return nil, &UnknownFunctionError{Name: string(chars[start:i]), Position: start}
+				}
+
+				tokenType = core.FUNC
 			default:
 				tokenType = core.IDENT
 
@@ -63,6 +84,62 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 				Type:      tokenType,
 				Value:     string(chars[start:i]),
 				ValueType: valueType,
+				Pos:       start,
+			})
+
+			continue
+		}
+
+		if isFormulaRef(char) {
+			start := i
+
+			i++
+			for i < inputLen && (isAlpha(chars[i]) || isDigit(chars[i]) || chars[i] == '_') {
+				i++
+			}
+
+			if i-start == 1 {
+				return nil, &InvalidTokenError{Position: start}
+			}
+
+			// The value type is set after all formulas are tokenized
+			tokens = append(tokens, core.Token{
+				Type:      core.FORMULA_REF,
+				Value:     string(chars[start+1 : i]),
+				ValueType: core.UNKNOWN_TYPE,
+				Pos:       start,
+			})
+
+			continue
+		}
+
+		// "string" with escapes like in Go or `raw string`, e.g. for regular expressions
+		if isQuote(char) {
+			start := i
+
+			i++
+			for i < inputLen && chars[i] != char {
+				if char == '"' && chars[i] == '\\' {
+					i++
+				}
+				i++
+			}
+
+			if i >= inputLen {
+				return nil, &InvalidTokenError{Position: start}
+			}
+
+			i++
+			value, err := strconv.Unquote(string(chars[start:i]))
+			if err != nil {
+				return nil, &InvalidTokenError{Position: start}
+			}
+
+			tokens = append(tokens, core.Token{
+				Type:      core.STRING,
+				Value:     value,
+				ValueType: core.STRING_TYPE,
+				Pos:       start,
 			})
 
 			continue
@@ -70,26 +147,32 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 
 		if isArithmeticOp(char) {
 			i++
-			tokens = append(tokens, core.Token{Type: core.ARITH_OP, Value: string(char)})
+			tokens = append(tokens, core.Token{Type: core.ARITH_OP, Value: string(char), Pos: i - 1})
 			continue
 		}
 
 		if isLeftBracket(char) {
 			i++
-			tokens = append(tokens, core.Token{Type: core.LBR, Value: string(char)})
+			tokens = append(tokens, core.Token{Type: core.LBR, Value: string(char), Pos: i - 1})
 			continue
 		}
 
 		if isRightBracket(char) {
 			i++
-			tokens = append(tokens, core.Token{Type: core.RBR, Value: string(char)})
+			tokens = append(tokens, core.Token{Type: core.RBR, Value: string(char), Pos: i - 1})
+			continue
+		}
+
+		if isComma(char) {
+			i++
+			tokens = append(tokens, core.Token{Type: core.COMMA, Value: string(char), Pos: i - 1})
 			continue
 		}
 
 		start := i
 		if isLogicOp(chars, &i, inputLen) {
 			i++
-			tokens = append(tokens, core.Token{Type: core.COMP_OP, Value: string(chars[start:i])})
+			tokens = append(tokens, core.Token{Type: core.COMP_OP, Value: string(chars[start:i]), Pos: start})
 			continue
 		}
 
@@ -99,6 +182,7 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 				Type:      core.NUMBER,
 				Value:     string(chars[start:i]),
 				ValueType: core.NUMBER_TYPE,
+				Pos:       start,
 			})
 			continue
 		}
@@ -109,8 +193,16 @@ func tokenize(input string, paramTypes map[string]core.ValueType) ([]core.Token,
 	return tokens, nil
 }
 
-func isExistsFunc(chars []rune) bool {
-	return string(chars) == "exists"
+func isMatchesOp(chars []rune) bool {
+	return string(chars) == "matches"
+}
+
+func isLetKeyword(chars []rune) bool {
+	return string(chars) == "let"
+}
+
+func isInKeyword(chars []rune) bool {
+	return string(chars) == "in"
 }
 
 var boolWords = map[string]struct{}{
@@ -149,6 +241,26 @@ func isRightBracket(char rune) bool {
 	return char == ')'
 }
 
+func isFormulaRef(char rune) bool {
+	return char == '@'
+}
+
+func isComma(char rune) bool {
+	return char == ','
+}
+
+func isQuote(char rune) bool {
+	return char == '"' || char == '`'
+}
+
+// isFuncCall checks whether the word ending at 'i' is followed by a bracket
+func isFuncCall(chars []rune, i int, inputLen int) bool {
+	for i < inputLen && unicode.IsSpace(chars[i]) {
+		i++
+	}
+	return i < inputLen && isLeftBracket(chars[i])
+}
+
 var arithmeticOp = map[string]struct{}{
 	"+": {},
 	"-": {},